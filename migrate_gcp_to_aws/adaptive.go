@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultPartConcurrency is how many parts of one S3 upload are sent at
+// once when concurrency is not adaptive
+const defaultPartConcurrency = 5
+
+// How the controller reacts, see Adjust
+const (
+	// Share of the limits kept after a decrease
+	adaptiveBackoff = 0.7
+	// Error rate above which the limits are cut
+	adaptiveMaxErrorRate = 0.05
+	// Latency, relative to the baseline, above which the limits are cut
+	adaptiveMaxLatency = 2.0
+	// Throughput, relative to the interval before, below which the last
+	// increase is undone
+	adaptiveMinGain = 0.9
+)
+
+// Concurrency limits how many workers copy at once and how many parts each
+// S3 upload sends at once. With adaptive concurrency both move within
+// their bounds: up by one while throughput keeps improving, cut by
+// adaptiveBackoff on throttling, errors or rising latency (AIMD).
+type Concurrency struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	adaptive bool
+	active   int
+
+	minWorkers, maxWorkers, workers int
+	minParts, maxParts, parts       int
+
+	// Observations since the last adjustment
+	bytes     atomic.Int64
+	successes int
+	failures  int
+	throttles int
+	busy      time.Duration
+	busyBytes int64
+
+	// What the last adjustment saw and did
+	lastThroughput float64
+	baseline       float64
+	increased      string
+}
+
+// concurrency is process wide, like the worker pool it gates
+var concurrency = newConcurrency()
+
+func newConcurrency() *Concurrency {
+	c := &Concurrency{parts: defaultPartConcurrency}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Configure sets the bounds from the configuration and starts halfway
+// between them
+func (c *Concurrency) Configure(config *Config) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.adaptive = config.Adaptive
+	c.minWorkers, c.maxWorkers = config.MinWorkers, config.MaxWorkers
+	c.minParts, c.maxParts = config.MinPartConcurrency, config.MaxPartConcurrency
+	c.workers = config.MaxWorkers
+	c.parts = defaultPartConcurrency
+	if c.adaptive {
+		c.workers = (c.minWorkers + c.maxWorkers + 1) / 2
+		c.parts = (c.minParts + c.maxParts + 1) / 2
+	}
+	c.cond.Broadcast()
+}
+
+// Acquire waits until the worker may start a copy. It returns at once
+// when a stop was requested, the worker then leaves the object alone.
+func (c *Concurrency) Acquire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.adaptive && c.active >= c.workers && !shutdown.Requested() {
+		c.cond.Wait()
+	}
+	c.active++
+}
+
+// Release ends a copy started with Acquire
+func (c *Concurrency) Release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active--
+	c.cond.Signal()
+}
+
+// Parts is how many parts an S3 upload sends at once
+func (c *Concurrency) Parts() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.parts
+}
+
+// Describe summarises the current limits for logs
+func (c *Concurrency) Describe() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.adaptive {
+		return fmt.Sprintf("%d workers, %d parts per upload", c.workers, c.parts)
+	}
+	return fmt.Sprintf("adaptive, %d workers (%d-%d), %d parts per upload (%d-%d)",
+		c.workers, c.minWorkers, c.maxWorkers, c.parts, c.minParts, c.maxParts)
+}
+
+// AddBytes counts bytes read by any worker
+func (c *Concurrency) AddBytes(n int) {
+	c.bytes.Add(int64(n))
+}
+
+// ObserveSuccess records a verified copy and how long it took
+func (c *Concurrency) ObserveSuccess(size int64, took time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.successes++
+	c.busy += took
+	c.busyBytes += size
+}
+
+// ObserveError records a failed attempt, retried or not
+func (c *Concurrency) ObserveError(class errorClass) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch class {
+	case classThrottled:
+		c.throttles++
+	case classCanceled, classPermanent, classChecksum:
+		// Not a sign of load
+	default:
+		c.failures++
+	}
+}
+
+// Start adjusts the limits every interval until the returned stop
+// function is called. Without adaptive concurrency it does nothing.
+func (c *Concurrency) Start(interval time.Duration, logger *TimestampLogger) func() {
+	if !c.adaptive {
+		return func() {}
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.Adjust(interval, logger)
+			case <-shutdown.Stopping():
+				// Wake the workers waiting in Acquire
+				c.mu.Lock()
+				c.cond.Broadcast()
+				c.mu.Unlock()
+				<-done
+				return
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// Adjust looks at what happened over the last interval and moves the
+// limits. Throttling, an error rate over adaptiveMaxErrorRate or latency
+// over adaptiveMaxLatency times its baseline cut them, an increase that
+// did not pay off is undone, otherwise they grow by one step.
+func (c *Concurrency) Adjust(interval time.Duration, logger *TimestampLogger) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	throughput := float64(c.bytes.Swap(0)) / interval.Seconds()
+	successes, failures, throttles := c.successes, c.failures, c.throttles
+	var latency float64
+	if c.busyBytes > 0 {
+		// Seconds per MB, so big and small objects weigh by their size
+		latency = c.busy.Seconds() / (float64(c.busyBytes) / 1e6)
+	}
+	c.successes, c.failures, c.throttles, c.busy, c.busyBytes = 0, 0, 0, 0, 0
+	if successes+failures+throttles == 0 && throughput == 0 {
+		// Nothing to go by
+		return
+	}
+
+	var reason string
+	errorRate := float64(failures) / float64(max(successes+failures, 1))
+	switch {
+	case throttles > 0:
+		reason = c.decrease(fmt.Sprintf("throttled %d times", throttles))
+	case errorRate > adaptiveMaxErrorRate:
+		reason = c.decrease(fmt.Sprintf("%.0f%% of attempts failed", 100*errorRate))
+	case c.baseline > 0 && latency > adaptiveMaxLatency*c.baseline:
+		reason = c.decrease(fmt.Sprintf("latency %.1fx its baseline", latency/c.baseline))
+	case c.increased != "" && throughput < adaptiveMinGain*c.lastThroughput:
+		reason = c.undo()
+	default:
+		reason = c.increase()
+	}
+
+	// The baseline follows the best latency quickly and worse ones slowly
+	if latency > 0 {
+		if c.baseline == 0 || latency < c.baseline {
+			c.baseline = latency
+		} else {
+			c.baseline += 0.1 * (latency - c.baseline)
+		}
+	}
+	c.lastThroughput = throughput
+	c.cond.Broadcast()
+
+	if reason != "" {
+		logger.Info("⚙ Concurrency adjusted", "workers", c.workers, "parts", c.parts, "reason", reason,
+			"throughput", formatRate(throughput), "latency_s_per_mb", math.Round(latency*1000)/1000)
+	}
+}
+
+// increase adds a worker, or a part per upload once all workers are in
+// use. It returns why, or "" when both are at their maximum.
+func (c *Concurrency) increase() string {
+	c.increased = ""
+	switch {
+	case c.workers < c.maxWorkers:
+		c.workers++
+		c.increased = "workers"
+	case c.parts < c.maxParts:
+		c.parts++
+		c.increased = "parts"
+	default:
+		return ""
+	}
+	return "throughput holding up, adding " + c.increased
+}
+
+// undo takes back the last increase
+func (c *Concurrency) undo() string {
+	switch c.increased {
+	case "workers":
+		c.workers = max(c.workers-1, c.minWorkers)
+	case "parts":
+		c.parts = max(c.parts-1, c.minParts)
+	}
+	reason := "more " + c.increased + " did not raise throughput"
+	c.increased = ""
+	return reason
+}
+
+// decrease cuts both limits
+func (c *Concurrency) decrease(why string) string {
+	c.increased = ""
+	workers := max(int(float64(c.workers)*adaptiveBackoff), c.minWorkers)
+	parts := max(int(float64(c.parts)*adaptiveBackoff), c.minParts)
+	if workers == c.workers && parts == c.parts {
+		return ""
+	}
+	c.workers, c.parts = workers, parts
+	return why + ", backing off"
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strings"
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// errChecksumMismatch marks copies whose bytes do not match the source object
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// Digest is the size and checksums of the bytes that were actually streamed
+type Digest struct {
+	Size   int64
+	MD5    []byte
+	CRC32C uint32
+}
+
+// checksumReader hashes every byte handed to a store so the copy can be
+// verified against the source object once the write has finished
+type checksumReader struct {
+	r      io.Reader
+	md5    hash.Hash
+	crc32c hash.Hash32
+	n      int64
+}
+
+func newChecksumReader(r io.Reader) *checksumReader {
+	return &checksumReader{
+		r:      r,
+		md5:    md5.New(),
+		crc32c: crc32.New(crc32cTable),
+	}
+}
+
+func (cr *checksumReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	if n > 0 {
+		cr.md5.Write(p[:n])
+		cr.crc32c.Write(p[:n])
+		cr.n += int64(n)
+	}
+	return n, err
+}
+
+func (cr *checksumReader) MD5() []byte {
+	return cr.md5.Sum(nil)
+}
+
+func (cr *checksumReader) CRC32C() uint32 {
+	return cr.crc32c.Sum32()
+}
+
+func (cr *checksumReader) Digest() Digest {
+	return Digest{Size: cr.n, MD5: cr.MD5(), CRC32C: cr.CRC32C()}
+}
+
+// crc32cBase64 encodes a CRC32C value the way S3 expects it in x-amz-checksum-crc32c
+func crc32cBase64(sum uint32) string {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], sum)
+	return base64.StdEncoding.EncodeToString(b[:])
+}
+
+// parseCRC32C decodes an x-amz-checksum-crc32c value. Composite checksums
+// of multipart uploads ("...-N") do not describe the whole object.
+func parseCRC32C(value string) (uint32, bool) {
+	b, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(b) != 4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(b), true
+}
+
+// verifyChecksums compares what was streamed with the size and whatever
+// digests the source recorded for the object. GCS always has a CRC32C, but
+// composite objects have no MD5.
+func verifyChecksums(src *ObjectInfo, got Digest) error {
+	if got.Size != src.Size {
+		return fmt.Errorf("%w: streamed %d bytes, source has %d", errChecksumMismatch, got.Size, src.Size)
+	}
+	if src.HasCRC32C && got.CRC32C != src.CRC32C {
+		return fmt.Errorf("%w: crc32c %08x, source has %08x", errChecksumMismatch, got.CRC32C, src.CRC32C)
+	}
+	if len(src.MD5) > 0 && !bytes.Equal(got.MD5, src.MD5) {
+		return fmt.Errorf("%w: md5 %x, source has %x", errChecksumMismatch, got.MD5, src.MD5)
+	}
+	return nil
+}
+
+// md5FromETag returns the MD5 an S3 ETag stands for. Multipart ETags
+// ("...-N") are not an MD5 of the object.
+func md5FromETag(etag string) []byte {
+	sum, err := hex.DecodeString(strings.Trim(etag, `"`))
+	if err != nil || len(sum) != md5.Size {
+		return nil
+	}
+	return sum
+}
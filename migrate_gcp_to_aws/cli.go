@@ -0,0 +1,413 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const usage = `Usage: migrate_gcp_to_aws <command> [flags]
+
+Commands:
+  plan          List the source and write a manifest of what run would do
+  run           Copy eligible objects from the source to the destination
+  verify        Re-check migrated objects against their source
+  report        Summarise the journal of earlier runs
+  retry-failed  Copy again every object whose last attempt failed
+
+Every setting in the config file can also be given as a flag (--s3-bucket)
+or an environment variable (MIGRATE_S3_BUCKET). Flags win over the
+environment, which wins over the config file.
+
+The source and destination default to gs://<gcs_bucket> and
+s3://<s3_bucket>. Either can be set to any store with --source and
+--destination: gs://bucket, s3://bucket or a local directory.
+
+A config file with a "jobs" list describes several migrations that share
+its top-level settings. Every command then works on all jobs, or on those
+named with --job; run copies for all of them at once on one worker pool.
+
+Run "migrate_gcp_to_aws <command> -h" to see all flags of a command.
+`
+
+// configFlag collects a config override from the command line, keyed by
+// the JSON name of the field
+type configFlag struct {
+	name   string
+	values map[string]string
+	isBool bool
+}
+
+func (f configFlag) String() string {
+	return f.values[f.name]
+}
+
+func (f configFlag) Set(value string) error {
+	f.values[f.name] = value
+	return nil
+}
+
+// IsBoolFlag lets boolean settings be given as a bare --flag
+func (f configFlag) IsBoolFlag() bool {
+	return f.isBool
+}
+
+// registerConfigFlags adds a flag for every config field and returns the
+// map the parsed values end up in
+func registerConfigFlags(fs *flag.FlagSet) map[string]string {
+	values := make(map[string]string)
+	config := &Config{}
+	for _, name := range configFieldNames() {
+		field, _ := config.field(name)
+		fs.Var(configFlag{name: name, values: values, isBool: field.Kind() == reflect.Bool}, configFlagName(name),
+			fmt.Sprintf("override %s (env %s)", name, configEnvName(name)))
+	}
+	return values
+}
+
+// resolveConfigPath picks the config file: --config, then MIGRATE_CONFIG,
+// then migrate_config.json if it exists. An empty result means defaults only.
+func resolveConfigPath(flagPath string) string {
+	if flagPath != "" {
+		return flagPath
+	}
+	if envPath := os.Getenv(envPrefix + "CONFIG"); envPath != "" {
+		return envPath
+	}
+	if _, err := os.Stat(DefaultConfigPath); err == nil {
+		return DefaultConfigPath
+	}
+	return ""
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	cmd, args := os.Args[1], os.Args[2:]
+
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	configPath := fs.String("config", "", "path to the JSON config file, an error if missing (default migrate_config.json when present)")
+	jobNames := fs.String("job", "", "comma separated jobs of the config file to work on (default all)")
+	overrides := registerConfigFlags(fs)
+
+	// Shared by the jobs of a multi-job run
+	var pool *workerPool
+
+	var run func(ctx context.Context, config *Config, logger *TimestampLogger) error
+	switch cmd {
+	case "plan":
+		out := fs.String("out", "migration_manifest.jsonl", "manifest to write (.jsonl or .csv)")
+		run = func(ctx context.Context, config *Config, logger *TimestampLogger) error {
+			return cmdPlan(ctx, config, logger, jobPath(*out, config.Job))
+		}
+	case "run":
+		manifestPath := fs.String("manifest", "", "copy only the objects approved in this manifest instead of listing the bucket")
+		run = func(ctx context.Context, config *Config, logger *TimestampLogger) error {
+			return cmdRun(ctx, config, logger, *manifestPath, pool)
+		}
+	case "verify":
+		manifestPath := fs.String("manifest", "", "verify the objects in this manifest instead of the journal")
+		deep := fs.Bool("deep", false, "download every S3 object and recompute its checksums")
+		run = func(ctx context.Context, config *Config, logger *TimestampLogger) error {
+			return cmdVerify(ctx, config, logger, *manifestPath, *deep)
+		}
+	case "report":
+		failed := fs.Bool("failed", false, "list every failed object with its reason")
+		run = func(ctx context.Context, config *Config, logger *TimestampLogger) error {
+			return cmdReport(config, logger, *failed)
+		}
+	case "retry-failed":
+		run = cmdRetryFailed
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", cmd, usage)
+		os.Exit(2)
+	}
+
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "Unexpected arguments: %v\n", fs.Args())
+		os.Exit(2)
+	}
+
+	source := resolveConfigPath(*configPath)
+	config, err := LoadConfig(source, overrides)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	var selected []string
+	for _, name := range strings.Split(*jobNames, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			selected = append(selected, name)
+		}
+	}
+	configs, err := LoadJobs(source, config, overrides, selected)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	if cmd == "run" && len(configs) > 1 && fs.Lookup("manifest").Value.String() != "" {
+		log.Fatalf("A manifest belongs to one job, pick it with --job")
+	}
+
+	// Create log directory
+	logDir := filepath.Dir(config.LogFile)
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		log.Fatalf("Failed to create log directory: %v", err)
+	}
+
+	// Initialize logger
+	logger, err := NewTimestampLogger(config)
+	if err != nil {
+		log.Fatalf("Failed to initialize logger: %v", err)
+	}
+
+	from := source
+	if from == "" {
+		from = "built-in defaults"
+	}
+	logger.Log("Command: %s", cmd)
+	logger.Log("Effective configuration (from %s, environment and flags):", from)
+	for _, line := range config.Summary() {
+		logger.Log("  %s", line)
+	}
+	logger.Log("")
+	for _, jobConfig := range configs {
+		if jobConfig.Job == "" {
+			continue
+		}
+		logger.Log("Job %s (settings of its own):", jobConfig.Job)
+		for _, line := range jobConfig.SummaryDiff(config) {
+			logger.Log("  %s", line)
+		}
+		logger.Log("")
+	}
+
+	// Limits can be changed while a migration runs by editing the config
+	// file and sending SIGHUP
+	limits.Apply(config)
+	concurrency.Configure(config)
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			updated, err := LoadConfig(source, overrides)
+			if err != nil {
+				logger.Warn("⚠ Reload failed, keeping current limits", "error", err)
+				continue
+			}
+			limits.Apply(updated)
+			logger.Log("↻ Reloaded limits: %s", limits.Describe())
+		}
+	}()
+
+	stopServer := func() {}
+	if config.MetricsAddr != "" {
+		if stopServer, err = startStatusServer(config.MetricsAddr, logger); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+
+	ctx, stopSignals := handleSignals(config.ShutdownGracePeriod, logger)
+	stopConcurrency := concurrency.Start(config.AdaptiveInterval, logger)
+	if configs[0].Job == "" {
+		err = run(ctx, config, logger)
+	} else {
+		parallel := cmd == "run"
+		if parallel {
+			pool = startWorkerPool(ctx, config.MaxWorkers)
+		}
+		err = runJobs(ctx, configs, logger, parallel, run)
+		if pool != nil {
+			pool.Close()
+		}
+	}
+	stopConcurrency()
+	stopSignals()
+	stopServer()
+	if errors.Is(err, errInterrupted) {
+		logger.Warn("Command interrupted", "command", cmd)
+		logger.Close()
+		os.Exit(130)
+	}
+	if err != nil {
+		logger.Error("Command failed", "command", cmd, "error", err)
+		logger.Close()
+		os.Exit(1)
+	}
+	logger.Close()
+}
+
+// openJournal creates the journal directory and opens the journal for
+// writing
+func openJournal(config *Config) (*Journal, error) {
+	if err := os.MkdirAll(filepath.Dir(config.JournalFile), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create journal directory: %w", err)
+	}
+	return OpenJournal(config.JournalFile)
+}
+
+func cmdPlan(ctx context.Context, config *Config, logger *TimestampLogger, out string) error {
+	src, dst, err := openStores(ctx, config, logger)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	defer dst.Close()
+
+	return runPlan(ctx, config, src, dst, logger, out)
+}
+
+func cmdRun(ctx context.Context, config *Config, logger *TimestampLogger, manifestPath string, pool *workerPool) error {
+	// Load the approved plan before any work starts
+	var manifest []ManifestEntry
+	if manifestPath != "" {
+		var err error
+		if manifest, err = ReadManifest(manifestPath); err != nil {
+			return err
+		}
+		logger.Log("Manifest: %s", manifestPath)
+	}
+
+	journal, err := openJournal(config)
+	if err != nil {
+		return err
+	}
+	defer journal.Close()
+
+	src, dst, err := openStores(ctx, config, logger)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	defer dst.Close()
+
+	started := time.Now()
+	budget := newDeletionBudget(config)
+	err = runMigration(ctx, config, src, dst, journal, logger, manifest, budget, pool)
+	// Only a full listing covers everything up to now, a manifest run only
+	// what was planned
+	if err == nil && manifest == nil {
+		if err := config.RecordRun(started); err != nil {
+			logger.Warn("⚠ Could not record the run", "error", err)
+		}
+	}
+	return moveAfter(ctx, config, src, dst, journal, budget, logger, err)
+}
+
+// moveAfter runs the move mode deletions after a migration. Sources whose
+// copies were verified are deleted even when other objects failed, but
+// not after an interrupt.
+func moveAfter(
+	ctx context.Context,
+	config *Config,
+	src ObjectStore,
+	dst ObjectStore,
+	journal *Journal,
+	budget *deletionBudget,
+	logger *TimestampLogger,
+	err error,
+) error {
+	if errors.Is(err, errInterrupted) {
+		return err
+	}
+	if derr := runDeletions(ctx, config, src, dst, journal, budget, logger); derr != nil && (err == nil || errors.Is(derr, errInterrupted)) {
+		return derr
+	}
+	return err
+}
+
+func cmdRetryFailed(ctx context.Context, config *Config, logger *TimestampLogger) error {
+	journal, err := openJournal(config)
+	if err != nil {
+		return err
+	}
+	defer journal.Close()
+
+	// Turn the failures back into an approved manifest
+	manifest := []ManifestEntry{}
+	for _, entry := range journal.Entries() {
+		if entry.State == JournalFailed {
+			// The journal does not keep the destination options, so they
+			// come from the current config
+			m := entry.ManifestEntry()
+			date, _ := time.ParseInLocation("2006-01-02", m.Date, config.Location)
+			m.S3Options = config.DestinationOptions(m.Source, m.Destination, date)
+			manifest = append(manifest, m)
+		}
+	}
+	if len(manifest) == 0 {
+		logger.Log("No failed files in %s, nothing to retry", config.JournalFile)
+		return nil
+	}
+	logger.Log("Retrying %d failed files from %s", len(manifest), config.JournalFile)
+
+	src, dst, err := openStores(ctx, config, logger)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	defer dst.Close()
+
+	budget := newDeletionBudget(config)
+	err = runMigration(ctx, config, src, dst, journal, logger, manifest, budget, nil)
+	return moveAfter(ctx, config, src, dst, journal, budget, logger, err)
+}
+
+func cmdVerify(ctx context.Context, config *Config, logger *TimestampLogger, manifestPath string, deep bool) error {
+	var entries []ManifestEntry
+	if manifestPath != "" {
+		manifest, err := ReadManifest(manifestPath)
+		if err != nil {
+			return err
+		}
+		// Objects that were to be copied or were already there
+		for _, entry := range manifest {
+			if entry.Decision == DecisionCopy || entry.Decision == DecisionSkipExists {
+				entries = append(entries, entry)
+			}
+		}
+	} else {
+		// Read only, a run may be appending to the journal
+		journal, err := ReadJournal(config.JournalFile)
+		if err != nil {
+			return err
+		}
+		for _, entry := range journal.Entries() {
+			if entry.State == JournalVerified {
+				entries = append(entries, entry.ManifestEntry())
+			}
+		}
+	}
+
+	src, dst, err := openStores(ctx, config, logger)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	defer dst.Close()
+
+	return runVerify(ctx, config, src, dst, logger, entries, deep)
+}
+
+func cmdReport(config *Config, logger *TimestampLogger, listFailed bool) error {
+	// Read only, a run may be appending to the journal
+	journal, err := ReadJournal(config.JournalFile)
+	if err != nil {
+		return err
+	}
+
+	return runReport(config, journal, logger, listFailed)
+}
@@ -0,0 +1,650 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Configuration struct
+type Config struct {
+	// Job is the name of the job this configuration is for, empty when the
+	// config file has no jobs (see JobConfig)
+	Job string `json:"-"`
+
+	GCSBucket          string   `json:"gcs_bucket"`
+	S3Bucket           string   `json:"s3_bucket"`
+	Source             string   `json:"source"`
+	Destination        string   `json:"destination"`
+	AWSCredentialsFile string   `json:"aws_credentials_file"`
+	AWSProfile         string   `json:"aws_profile"`
+	AWSRegion          string   `json:"aws_region"`
+	LogFile            string   `json:"log_file"`
+	JournalFile        string   `json:"journal_file"`
+	MaxWorkers         int      `json:"max_workers"`
+	VideoExtensions    []string `json:"video_extensions"`
+
+	// Endpoints other than AWS and Google, for S3-compatible stores (MinIO,
+	// Ceph, R2) and emulators (MinIO, fake-gcs-server), e.g.
+	// "http://localhost:9000". Most S3-compatible stores need the bucket in
+	// the path rather than the host name (S3ForcePathStyle).
+	// S3InsecureSkipVerify accepts any TLS certificate and is meant for
+	// local test setups. GCSNoAuth sends GCS requests without credentials.
+	S3Endpoint           string `json:"s3_endpoint"`
+	S3ForcePathStyle     bool   `json:"s3_force_path_style"`
+	S3InsecureSkipVerify bool   `json:"s3_insecure_skip_verify"`
+	GCSEndpoint          string `json:"gcs_endpoint"`
+	GCSNoAuth            bool   `json:"gcs_no_auth"`
+
+	// Logging: format is text or json, level is debug, info, warn or
+	// error. Quiet leaves out the per-object lines.
+	LogFormat     string     `json:"log_format"`
+	LogLevel      string     `json:"log_level"`
+	LogLevelValue slog.Level `json:"-"`
+	Quiet         bool       `json:"quiet"`
+
+	// Date window, both ends inclusive. Dates are YYYY-MM-DD, "today",
+	// "yesterday", relative ("last 30d", "2w ago") or "last-run". An empty
+	// end date means no upper bound. Folder dates are read in Timezone.
+	CutoffDate    time.Time      `json:"-"`
+	CutoffDateStr string         `json:"cutoff_date"`
+	EndDate       time.Time      `json:"-"`
+	EndDateStr    string         `json:"end_date"`
+	Timezone      string         `json:"timezone"`
+	Location      *time.Location `json:"-"`
+
+	// Metadata written with every copy. Content headers are always kept.
+	// CopyMetadata carries the source's user metadata across (as
+	// x-amz-meta-* on S3), ProvenanceMetadata adds source-uri,
+	// source-generation, source-created and source-updated. MetadataMap
+	// renames keys, a key mapped to "" is dropped.
+	CopyMetadata       bool              `json:"copy_metadata"`
+	ProvenanceMetadata bool              `json:"provenance_metadata"`
+	MetadataMap        map[string]string `json:"metadata_map"`
+
+	// How objects are written to an S3 destination: storage class,
+	// encryption, ACL, tags and object lock (see DestinationOptions). Key
+	// rules can override them for the keys they apply to.
+	S3Options DestinationOptions `json:"s3_options"`
+
+	// Multipart sizing of S3 uploads (see PartSizing): objects under
+	// MultipartThreshold go up in a single PUT, larger ones in parts of
+	// MinPartSize up to MaxPartSize, as large as the 10,000 part limit
+	// needs. A worker buffers up to max_part_concurrency parts in memory.
+	MultipartThreshold      string `json:"multipart_threshold"`
+	MultipartThresholdBytes int64  `json:"-"`
+	MinPartSize             string `json:"min_part_size"`
+	MinPartSizeBytes        int64  `json:"-"`
+	MaxPartSize             string `json:"max_part_size"`
+	MaxPartSizeBytes        int64  `json:"-"`
+
+	// Shared limits across all workers, zero or empty means unlimited.
+	// Bandwidth is a size per second ("50MB"), ops are API requests per
+	// second. They are re-read from the config file on SIGHUP.
+	BandwidthLimit      string  `json:"bandwidth_limit"`
+	BandwidthLimitBytes int64   `json:"-"`
+	GCSOpsPerSec        float64 `json:"gcs_ops_per_sec"`
+	S3OpsPerSec         float64 `json:"s3_ops_per_sec"`
+
+	// Adaptive concurrency moves the number of copying workers between
+	// MinWorkers and MaxWorkers, and the parts each S3 upload sends at once
+	// between MinPartConcurrency and MaxPartConcurrency, every
+	// AdaptiveInterval (see Concurrency). Without it MaxWorkers copy at once.
+	Adaptive            bool          `json:"adaptive"`
+	MinWorkers          int           `json:"min_workers"`
+	MinPartConcurrency  int           `json:"min_part_concurrency"`
+	MaxPartConcurrency  int           `json:"max_part_concurrency"`
+	AdaptiveInterval    time.Duration `json:"-"`
+	AdaptiveIntervalStr string        `json:"adaptive_interval"`
+
+	// Directory for the per-object result reports of each run: a CSV and a
+	// JSONL file with one line per object, and an HTML summary broken down
+	// by port and date. Empty disables them.
+	ReportDir string `json:"report_dir"`
+
+	// Progress display: "bar" redraws a status bar on the terminal, "log"
+	// writes an update every ProgressInterval, "auto" picks the bar when
+	// stdout is a terminal and logs are text, "off" shows nothing
+	Progress            string        `json:"progress"`
+	ProgressInterval    time.Duration `json:"-"`
+	ProgressIntervalStr string        `json:"progress_interval"`
+
+	// Address of the optional HTTP server with /metrics (Prometheus) and
+	// /status (JSON), e.g. "127.0.0.1:9090". Empty disables it.
+	MetricsAddr string `json:"metrics_addr"`
+
+	// Source listing fans out over "/" prefixes down to ListShardDepth
+	// levels (port folders, then date folders), with up to ListConcurrency
+	// listings running at once; 1 lists the bucket in one pass.
+	// SourceInventory lists from a CSV or JSONL inventory file instead of
+	// the API (see readInventory).
+	ListConcurrency int    `json:"list_concurrency"`
+	ListShardDepth  int    `json:"list_shard_depth"`
+	SourceInventory string `json:"source_inventory"`
+
+	// Object selection (see Filter). Without an include filter only
+	// files with one of VideoExtensions are migrated.
+	Include *Filter `json:"include"`
+	Exclude *Filter `json:"exclude"`
+
+	// Where an object's date comes from, tried in order (see DateSource)
+	DateSources []DateSource `json:"date_sources"`
+
+	// Destination key rewriting, first matching rule wins (see KeyRule)
+	KeyRules []KeyRule `json:"key_rules"`
+
+	// Retry policy for a single object copy. Durations use Go syntax
+	// ("500ms", "2m"); an empty attempt timeout means no limit.
+	RetryMaxAttempts  int           `json:"retry_max_attempts"`
+	RetryBaseDelay    time.Duration `json:"-"`
+	RetryBaseDelayStr string        `json:"retry_base_delay"`
+	RetryMaxDelay     time.Duration `json:"-"`
+	RetryMaxDelayStr  string        `json:"retry_max_delay"`
+	AttemptTimeout    time.Duration `json:"-"`
+	AttemptTimeoutStr string        `json:"attempt_timeout"`
+
+	// Move mode deletes a source object once its copy was verified at
+	// least MoveDelay ago. Deletions are pinned to the copied generation,
+	// appended to DeletionManifest and capped at MaxDeletes per run, see
+	// deletionBudget.
+	Move             bool          `json:"move"`
+	MoveDelay        time.Duration `json:"-"`
+	MoveDelayStr     string        `json:"move_delay"`
+	MaxDeletes       int           `json:"max_deletes"`
+	DeletionManifest string        `json:"deletion_manifest"`
+
+	// Sync mode compares existing destination objects with their source
+	// instead of treating them as done, and overwrites those that differ.
+	// SyncCompare is any of size, checksum and mtime. SyncDelete also
+	// removes destination objects no source object maps to, like rsync
+	// --delete; those deletions share the MaxDeletes cap. They are limited
+	// to the part of the destination this config writes, see
+	// syncDeleteScope.
+	Sync             bool     `json:"sync"`
+	SyncCompare      []string `json:"sync_compare"`
+	SyncDelete       bool     `json:"sync_delete"`
+	SyncDeletePrefix string   `json:"sync_delete_prefix"`
+	SyncDeleteScope  string   `json:"-"`
+
+	// How long in-flight copies may run on after SIGINT or SIGTERM
+	ShutdownGracePeriod    time.Duration `json:"-"`
+	ShutdownGracePeriodStr string        `json:"shutdown_grace_period"`
+}
+
+// DefaultConfigPath is read when it exists and no --config was given
+const DefaultConfigPath = "migrate_config.json"
+
+// envPrefix is prepended to the upper-cased JSON name of a field to get
+// its environment variable, e.g. MIGRATE_S3_BUCKET
+const envPrefix = "MIGRATE_"
+
+// LoadConfig builds the effective configuration. Later sources win:
+// defaults, the JSON file at configPath (if any), MIGRATE_* environment
+// variables, then overrides keyed by JSON field name (from flags).
+func LoadConfig(configPath string, overrides map[string]string) (*Config, error) {
+	return loadConfig(configPath, nil, overrides)
+}
+
+// loadConfig is LoadConfig with the settings of job, if any, applied over
+// the top level of the config file
+func loadConfig(configPath string, job *JobConfig, overrides map[string]string) (*Config, error) {
+	config := &Config{
+		GCSBucket:              "",
+		S3Bucket:               "",
+		Source:                 "",
+		Destination:            "",
+		AWSCredentialsFile:     "",
+		AWSProfile:             "default",
+		AWSRegion:              "",
+		LogFile:                "logs/migrate_gcp_to_s3.log",
+		LogFormat:              logFormatText,
+		LogLevel:               "info",
+		CutoffDateStr:          "2025-09-07",
+		EndDateStr:             "",
+		Timezone:               "UTC",
+		MaxWorkers:             20,
+		MinWorkers:             1,
+		MinPartConcurrency:     1,
+		MaxPartConcurrency:     10,
+		MultipartThreshold:     "64MiB",
+		MinPartSize:            "10MiB",
+		MaxPartSize:            "5GiB",
+		AdaptiveIntervalStr:    "15s",
+		ListConcurrency:        8,
+		ListShardDepth:         2,
+		VideoExtensions:        []string{".mp4", ".avi", ".mov", ".mkv", ".webm", ".m4v"},
+		DateSources:            defaultDateSources(),
+		CopyMetadata:           true,
+		ProvenanceMetadata:     true,
+		RetryMaxAttempts:       5,
+		RetryBaseDelayStr:      "1s",
+		RetryMaxDelayStr:       "1m",
+		AttemptTimeoutStr:      "",
+		MoveDelayStr:           "24h",
+		MaxDeletes:             1000,
+		SyncCompare:            []string{compareSize, compareChecksum},
+		ShutdownGracePeriodStr: "30s",
+		Progress:               progressAuto,
+		ProgressIntervalStr:    "10s",
+	}
+
+	// A config path is only passed when it was asked for or exists, so a
+	// missing or unreadable file is an error
+	if configPath != "" {
+		data, err := os.ReadFile(configPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		if err := json.Unmarshal(data, config); err != nil {
+			return nil, fmt.Errorf("failed to parse config file: %w", err)
+		}
+	}
+	if job != nil {
+		if err := json.Unmarshal(job.Settings, config); err != nil {
+			return nil, fmt.Errorf("failed to parse job %s: %w", job.Name, err)
+		}
+		config.Job = job.Name
+	}
+
+	// Environment variables override the file
+	for _, name := range configFieldNames() {
+		if value, ok := os.LookupEnv(configEnvName(name)); ok {
+			if err := config.Set(name, value); err != nil {
+				return nil, fmt.Errorf("%s: %w", configEnvName(name), err)
+			}
+		}
+	}
+
+	// Flags override everything
+	names := make([]string, 0, len(overrides))
+	for name := range overrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := config.Set(name, overrides[name]); err != nil {
+			return nil, fmt.Errorf("--%s: %w", configFlagName(name), err)
+		}
+	}
+
+	if err := config.resolve(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// resolve parses the string forms of dates and durations and fills in
+// derived defaults
+func (c *Config) resolve() error {
+	// Keep the journal next to the log file unless told otherwise, each
+	// job in a directory of its own
+	if c.JournalFile == "" {
+		c.JournalFile = filepath.Join(filepath.Dir(c.LogFile), "migrate_journal.jsonl")
+		if c.Job != "" {
+			c.JournalFile = filepath.Join(filepath.Dir(c.LogFile), "jobs", c.Job, "migrate_journal.jsonl")
+		}
+	}
+	if c.DeletionManifest == "" {
+		c.DeletionManifest = filepath.Join(filepath.Dir(c.JournalFile), "migrate_deletions.jsonl")
+	}
+
+	if c.LogFormat != logFormatText && c.LogFormat != logFormatJSON {
+		return fmt.Errorf("log_format must be %s or %s, got %q", logFormatText, logFormatJSON, c.LogFormat)
+	}
+	var err error
+	if c.LogLevelValue, err = parseLogLevel(c.LogLevel); err != nil {
+		return err
+	}
+
+	// Parse the date window in its timezone
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return fmt.Errorf("failed to load timezone: %w", err)
+	}
+	c.Location = loc
+	now := time.Now()
+	if c.CutoffDate, err = parseDateExpr(c.CutoffDateStr, now, loc, c.LastRun); err != nil {
+		return fmt.Errorf("failed to parse cutoff date: %w", err)
+	}
+	c.EndDate = time.Time{}
+	if c.EndDateStr != "" {
+		if c.EndDate, err = parseDateExpr(c.EndDateStr, now, loc, c.LastRun); err != nil {
+			return fmt.Errorf("failed to parse end date: %w", err)
+		}
+		if c.EndDate.Before(c.CutoffDate) {
+			return fmt.Errorf("end date %s is before cutoff date %s",
+				c.EndDate.Format("2006-01-02"), c.CutoffDate.Format("2006-01-02"))
+		}
+	}
+
+	// Parse retry durations
+	if c.RetryBaseDelay, err = parseDuration("retry_base_delay", c.RetryBaseDelayStr); err != nil {
+		return err
+	}
+	if c.RetryMaxDelay, err = parseDuration("retry_max_delay", c.RetryMaxDelayStr); err != nil {
+		return err
+	}
+	if c.AttemptTimeout, err = parseDuration("attempt_timeout", c.AttemptTimeoutStr); err != nil {
+		return err
+	}
+	if c.ShutdownGracePeriod, err = parseDuration("shutdown_grace_period", c.ShutdownGracePeriodStr); err != nil {
+		return err
+	}
+	if c.MoveDelay, err = parseDuration("move_delay", c.MoveDelayStr); err != nil {
+		return err
+	}
+	if c.ProgressInterval, err = parseDuration("progress_interval", c.ProgressIntervalStr); err != nil {
+		return err
+	}
+	if c.AdaptiveInterval, err = parseDuration("adaptive_interval", c.AdaptiveIntervalStr); err != nil {
+		return err
+	}
+	if c.Adaptive && c.AdaptiveInterval <= 0 {
+		return fmt.Errorf("adaptive_interval must be positive with adaptive")
+	}
+	switch c.Progress {
+	case progressAuto, progressBar, progressLog, progressOff:
+	default:
+		return fmt.Errorf("progress must be %s, %s, %s or %s, got %q", progressAuto, progressBar, progressLog, progressOff, c.Progress)
+	}
+	if (c.Move || c.SyncDelete) && c.MaxDeletes < 1 {
+		return fmt.Errorf("max_deletes must be at least 1 in move mode or with sync_delete, got %d", c.MaxDeletes)
+	}
+	if c.SyncDelete && !c.Sync {
+		return fmt.Errorf("sync_delete needs sync")
+	}
+	// An inventory misses objects added since it was taken, and sync_delete
+	// would delete their copies
+	if c.SyncDelete && c.SourceInventory != "" {
+		return fmt.Errorf("sync_delete needs a listing of the source, not source_inventory")
+	}
+	if c.SyncDeletePrefix != "" && !c.SyncDelete {
+		return fmt.Errorf("sync_delete_prefix needs sync_delete")
+	}
+	for _, compare := range c.SyncCompare {
+		if compare != compareSize && compare != compareChecksum && compare != compareMtime {
+			return fmt.Errorf("sync_compare: unknown comparison %q (use %s, %s or %s)", compare, compareSize, compareChecksum, compareMtime)
+		}
+	}
+
+	if c.BandwidthLimitBytes, err = parseSize(c.BandwidthLimit, 0); err != nil {
+		return fmt.Errorf("bandwidth_limit: %w", err)
+	}
+	if c.MultipartThresholdBytes, err = parseSize(c.MultipartThreshold, 0); err != nil {
+		return fmt.Errorf("multipart_threshold: %w", err)
+	}
+	if c.MinPartSizeBytes, err = parseSize(c.MinPartSize, s3MinPartSize); err != nil {
+		return fmt.Errorf("min_part_size: %w", err)
+	}
+	if c.MaxPartSizeBytes, err = parseSize(c.MaxPartSize, s3MaxPartSize); err != nil {
+		return fmt.Errorf("max_part_size: %w", err)
+	}
+	if c.MinPartSizeBytes < s3MinPartSize || c.MaxPartSizeBytes > s3MaxPartSize || c.MinPartSizeBytes > c.MaxPartSizeBytes {
+		return fmt.Errorf("min_part_size and max_part_size must be within %s and %s, min first, got %s and %s",
+			formatBytes(s3MinPartSize), formatBytes(s3MaxPartSize), c.MinPartSize, c.MaxPartSize)
+	}
+	if c.MultipartThresholdBytes > s3MaxPartSize {
+		return fmt.Errorf("multipart_threshold must be at most %s, the largest single PUT, got %s", formatBytes(s3MaxPartSize), c.MultipartThreshold)
+	}
+
+	if c.Include != nil {
+		if err := c.Include.compile(); err != nil {
+			return fmt.Errorf("include: %w", err)
+		}
+	}
+	if c.Exclude != nil {
+		if err := c.Exclude.compile(); err != nil {
+			return fmt.Errorf("exclude: %w", err)
+		}
+	}
+
+	// Local files have no content type, storage class or metadata to
+	// filter on, a filter reading them would skip everything
+	if src, _, err := c.StoreURIs(); err == nil && !strings.HasPrefix(src, "gs://") && !strings.HasPrefix(src, "s3://") {
+		attrs := c.FilterAttributes()
+		for _, attr := range []string{attrContentType, attrStorageClass, attrMetadata} {
+			if attrs[attr] {
+				return fmt.Errorf("include/exclude filter on %s, which local source %s does not have", attr, src)
+			}
+		}
+	}
+
+	if len(c.DateSources) == 0 {
+		c.DateSources = defaultDateSources()
+	}
+	for i := range c.DateSources {
+		if err := c.DateSources[i].compile(); err != nil {
+			return fmt.Errorf("date_sources[%d]: %w", i, err)
+		}
+	}
+
+	if err := c.S3Options.compile(); err != nil {
+		return fmt.Errorf("s3_options: %w", err)
+	}
+	for i := range c.KeyRules {
+		if err := c.KeyRules[i].compile(c.S3Options); err != nil {
+			return fmt.Errorf("key_rules[%d]: %w", i, err)
+		}
+	}
+	if c.SyncDelete {
+		if c.SyncDeleteScope, err = c.syncDeleteScope(); err != nil {
+			return err
+		}
+	}
+
+	if err := checkEndpoint("s3_endpoint", c.S3Endpoint); err != nil {
+		return err
+	}
+	if err := checkEndpoint("gcs_endpoint", c.GCSEndpoint); err != nil {
+		return err
+	}
+
+	if c.MaxWorkers < 1 {
+		return fmt.Errorf("max_workers must be at least 1, got %d", c.MaxWorkers)
+	}
+	if c.MinWorkers < 1 || c.MinWorkers > c.MaxWorkers {
+		return fmt.Errorf("min_workers must be between 1 and max_workers (%d), got %d", c.MaxWorkers, c.MinWorkers)
+	}
+	if c.MinPartConcurrency < 1 || c.MinPartConcurrency > c.MaxPartConcurrency {
+		return fmt.Errorf("min_part_concurrency must be between 1 and max_part_concurrency (%d), got %d", c.MaxPartConcurrency, c.MinPartConcurrency)
+	}
+	if c.ListConcurrency < 1 {
+		return fmt.Errorf("list_concurrency must be at least 1, got %d", c.ListConcurrency)
+	}
+	if c.ListShardDepth < 0 {
+		return fmt.Errorf("list_shard_depth must not be negative, got %d", c.ListShardDepth)
+	}
+
+	return nil
+}
+
+// StoreURIs returns the source and destination stores. Without an explicit
+// URI the buckets are used, gs:// for the source and s3:// for the
+// destination.
+func (c *Config) StoreURIs() (string, string, error) {
+	src, dst := c.Source, c.Destination
+	if src == "" && c.GCSBucket != "" {
+		src = "gs://" + c.GCSBucket
+	}
+	if dst == "" && c.S3Bucket != "" {
+		dst = "s3://" + c.S3Bucket
+	}
+	if src == "" {
+		return "", "", fmt.Errorf("no source set (use --source, --gcs-bucket or %s)", configEnvName("gcs_bucket"))
+	}
+	if dst == "" {
+		return "", "", fmt.Errorf("no destination set (use --destination, --s3-bucket or %s)", configEnvName("s3_bucket"))
+	}
+	if src == dst {
+		return "", "", fmt.Errorf("source and destination are both %s", src)
+	}
+	return src, dst, nil
+}
+
+// parseDuration parses an optional duration setting, empty means zero
+func parseDuration(name, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+	return d, nil
+}
+
+// checkEndpoint accepts an empty endpoint or an http(s) URL
+func checkEndpoint(name, endpoint string) error {
+	if endpoint == "" {
+		return nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s must be an http:// or https:// URL, got %q", name, endpoint)
+	}
+	return nil
+}
+
+// PartSizing builds the S3 multipart sizing from the configuration
+func (c *Config) PartSizing() PartSizing {
+	return PartSizing{MinPart: c.MinPartSizeBytes, MaxPart: c.MaxPartSizeBytes, SinglePutMax: c.MultipartThresholdBytes}
+}
+
+// RetryPolicy builds the per-object retry policy from the configuration
+func (c *Config) RetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    c.RetryMaxAttempts,
+		BaseDelay:      c.RetryBaseDelay,
+		MaxDelay:       c.RetryMaxDelay,
+		AttemptTimeout: c.AttemptTimeout,
+	}
+}
+
+// configFieldNames returns the JSON names of all settable fields, in
+// declaration order
+func configFieldNames() []string {
+	t := reflect.TypeOf(Config{})
+	names := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if name := jsonName(t.Field(i)); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func jsonName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+func configEnvName(name string) string {
+	return envPrefix + strings.ToUpper(name)
+}
+
+func configFlagName(name string) string {
+	return strings.ReplaceAll(name, "_", "-")
+}
+
+// field finds a struct field by its JSON name
+func (c *Config) field(name string) (reflect.Value, bool) {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if jsonName(t.Field(i)) == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// Set assigns a field from its string form. Lists are comma separated and
+// anything more structured is given as JSON.
+func (c *Config) Set(name, value string) error {
+	field, ok := c.field(name)
+	if !ok {
+		return fmt.Errorf("unknown setting %q", name)
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		field.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(strings.TrimSpace(value), "[") {
+			var items []string
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			field.Set(reflect.ValueOf(items))
+			return nil
+		}
+		fallthrough
+	default:
+		ptr := reflect.New(field.Type())
+		if err := json.Unmarshal([]byte(value), ptr.Interface()); err != nil {
+			return fmt.Errorf("invalid JSON value: %w", err)
+		}
+		field.Set(ptr.Elem())
+	}
+	return nil
+}
+
+// Summary describes the effective configuration, one setting per line
+func (c *Config) Summary() []string {
+	var lines []string
+	for _, name := range configFieldNames() {
+		field, _ := c.field(name)
+		var value string
+		switch field.Kind() {
+		case reflect.String:
+			value = field.String()
+			if value == "" {
+				value = "(not set)"
+			}
+		case reflect.Slice:
+			if items, ok := field.Interface().([]string); ok {
+				value = strings.Join(items, ",")
+				break
+			}
+			fallthrough
+		case reflect.Map, reflect.Struct, reflect.Ptr:
+			data, _ := json.Marshal(field.Interface())
+			value = string(data)
+		default:
+			value = fmt.Sprint(field.Interface())
+		}
+		lines = append(lines, fmt.Sprintf("%-22s %s", name+":", value))
+	}
+	return lines
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Date source types
+const (
+	DateFromSegment  = "segment"
+	DateFromRegex    = "regex"
+	DateFromFilename = "filename"
+	DateFromCreated  = "created"
+	DateFromUpdated  = "updated"
+)
+
+// DateSource is one way of finding the date an object belongs to. Sources
+// are tried in order and the first one that yields a date wins.
+//
+//   - segment: parse a path segment with Layout. Segment picks one
+//     (negative counts from the end), without it every segment is tried.
+//   - regex: match Pattern against the full key. Named groups year, month
+//     and day (optionally hour, minute, second) give the date, or a group
+//     named date is parsed with Layout.
+//   - filename: find a timestamp in Layout anywhere in the file name, e.g.
+//     recording_20250907_101500 with the default layout 20060102_150405.
+//   - created, updated: the object's own timestamps.
+type DateSource struct {
+	Type    string `json:"type"`
+	Pattern string `json:"pattern,omitempty"`
+	Layout  string `json:"layout,omitempty"`
+	Segment *int   `json:"segment,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// defaultDateSources is the layout the migrator was written for:
+// port1/2025-09-07/recording.mp4
+func defaultDateSources() []DateSource {
+	segment := 1
+	return []DateSource{{Type: DateFromSegment, Layout: "2006-01-02", Segment: &segment}}
+}
+
+var errNoDate = errors.New("no date found")
+
+// compile checks the source and fills in default layouts
+func (d *DateSource) compile() error {
+	switch d.Type {
+	case DateFromSegment:
+		if d.Layout == "" {
+			d.Layout = "2006-01-02"
+		}
+	case DateFromFilename:
+		if d.Layout == "" {
+			d.Layout = "20060102_150405"
+		}
+	case DateFromRegex:
+		re, err := regexp.Compile(d.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern: %w", err)
+		}
+		if re.SubexpIndex("date") >= 0 {
+			if d.Layout == "" {
+				return errors.New("a date group needs a layout")
+			}
+		} else if re.SubexpIndex("year") < 0 || re.SubexpIndex("month") < 0 || re.SubexpIndex("day") < 0 {
+			return errors.New("pattern needs a date group or year, month and day groups")
+		}
+		d.re = re
+	case DateFromCreated, DateFromUpdated:
+	default:
+		return fmt.Errorf("unknown date source type %q", d.Type)
+	}
+	return nil
+}
+
+// extract finds the date of an object, or returns errNoDate
+func (d *DateSource) extract(info *ObjectInfo, loc *time.Location) (time.Time, error) {
+	switch d.Type {
+	case DateFromSegment:
+		parts := strings.Split(info.Key, "/")
+		if d.Segment != nil {
+			i := *d.Segment
+			if i < 0 {
+				i += len(parts)
+			}
+			if i < 0 || i >= len(parts) {
+				return time.Time{}, fmt.Errorf("%w: path has no segment %d", errNoDate, *d.Segment)
+			}
+			date, err := time.ParseInLocation(d.Layout, parts[i], loc)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("%w: segment %q is not a %s date", errNoDate, parts[i], d.Layout)
+			}
+			return date, nil
+		}
+		for _, part := range parts {
+			if date, err := time.ParseInLocation(d.Layout, part, loc); err == nil {
+				return date, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("%w: no segment is a %s date", errNoDate, d.Layout)
+
+	case DateFromRegex:
+		m := d.re.FindStringSubmatch(info.Key)
+		if m == nil {
+			return time.Time{}, fmt.Errorf("%w: path does not match %s", errNoDate, d.Pattern)
+		}
+		if i := d.re.SubexpIndex("date"); i >= 0 {
+			date, err := time.ParseInLocation(d.Layout, m[i], loc)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("%w: %q is not a %s date", errNoDate, m[i], d.Layout)
+			}
+			return date, nil
+		}
+		group := func(name string) int {
+			if i := d.re.SubexpIndex(name); i >= 0 {
+				n, _ := strconv.Atoi(m[i])
+				return n
+			}
+			return 0
+		}
+		year, month, day := group("year"), group("month"), group("day")
+		date := time.Date(year, time.Month(month), day, group("hour"), group("minute"), group("second"), 0, loc)
+		// time.Date normalises out of range values, so reject them here
+		if date.Year() != year || int(date.Month()) != month || date.Day() != day {
+			return time.Time{}, fmt.Errorf("%w: %04d-%02d-%02d is not a valid date", errNoDate, year, month, day)
+		}
+		return date, nil
+
+	case DateFromFilename:
+		name := path.Base(info.Key)
+		for i := 0; i+len(d.Layout) <= len(name); i++ {
+			if date, err := time.ParseInLocation(d.Layout, name[i:i+len(d.Layout)], loc); err == nil {
+				return date, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("%w: file name has no %s timestamp", errNoDate, d.Layout)
+
+	case DateFromCreated:
+		if info.Created.IsZero() {
+			return time.Time{}, fmt.Errorf("%w: object has no creation time", errNoDate)
+		}
+		return info.Created.In(loc), nil
+
+	case DateFromUpdated:
+		if info.Updated.IsZero() {
+			return time.Time{}, fmt.Errorf("%w: object has no update time", errNoDate)
+		}
+		return info.Updated.In(loc), nil
+	}
+	return time.Time{}, fmt.Errorf("unknown date source type %q", d.Type)
+}
+
+// ObjectDate finds the date of an object with the configured sources. The
+// error names what every source tried.
+func (c *Config) ObjectDate(info *ObjectInfo) (time.Time, error) {
+	var reasons []string
+	for i := range c.DateSources {
+		date, err := c.DateSources[i].extract(info, c.Location)
+		if err == nil {
+			return date, nil
+		}
+		reasons = append(reasons, c.DateSources[i].Type+": "+strings.TrimPrefix(err.Error(), errNoDate.Error()+": "))
+	}
+	return time.Time{}, fmt.Errorf("%w (%s)", errNoDate, strings.Join(reasons, "; "))
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// maxObjectTags is the most tags S3 keeps on one object
+const maxObjectTags = 10
+
+// DestinationOptions are how objects are written to an S3 destination.
+// Empty settings leave the bucket defaults in place.
+//
+//	{"storage_class": "STANDARD_IA", "kms_key_id": "alias/recordings",
+//	 "tags": {"source": "{source}", "port": "{port}", "date": "{date}"},
+//	 "object_lock_mode": "COMPLIANCE", "object_lock_retention": "365d"}
+type DestinationOptions struct {
+	// Storage class, e.g. STANDARD_IA or GLACIER_IR
+	StorageClass string `json:"storage_class,omitempty"`
+	// Server-side encryption, AES256 or aws:kms. A KMS key implies aws:kms.
+	SSE      string `json:"sse,omitempty"`
+	KMSKeyID string `json:"kms_key_id,omitempty"`
+	// Canned ACL, e.g. private or bucket-owner-full-control
+	ACL string `json:"acl,omitempty"`
+	// Object tags. Values are templates with the key rule variables of the
+	// source key plus {source} and {destination}.
+	Tags map[string]string `json:"tags,omitempty"`
+	// Object lock, GOVERNANCE or COMPLIANCE, kept for the retention
+	// period ("365d", "12w") from the time of the copy
+	ObjectLockMode      string `json:"object_lock_mode,omitempty"`
+	ObjectLockRetention string `json:"object_lock_retention,omitempty"`
+
+	retention time.Duration
+}
+
+// compile checks the options and normalises their case
+func (o *DestinationOptions) compile() error {
+	var err error
+	if o.StorageClass, err = s3Enum("storage_class", o.StorageClass, s3.StorageClass_Values()); err != nil {
+		return err
+	}
+	if o.KMSKeyID != "" && o.SSE == "" {
+		o.SSE = s3.ServerSideEncryptionAwsKms
+	}
+	if o.SSE, err = s3Enum("sse", o.SSE, s3.ServerSideEncryption_Values()); err != nil {
+		return err
+	}
+	if o.KMSKeyID != "" && !strings.HasPrefix(o.SSE, "aws:kms") {
+		return fmt.Errorf("kms_key_id needs sse aws:kms, got %s", o.SSE)
+	}
+	if o.ACL, err = s3Enum("acl", o.ACL, s3.ObjectCannedACL_Values()); err != nil {
+		return err
+	}
+
+	if len(o.Tags) > maxObjectTags {
+		return fmt.Errorf("%d tags, S3 keeps at most %d", len(o.Tags), maxObjectTags)
+	}
+	for key, value := range o.Tags {
+		if key == "" {
+			return fmt.Errorf("empty tag key")
+		}
+		for _, m := range templateVar.FindAllStringSubmatch(value, -1) {
+			if name := m[1]; !templateVars[name] && name != "source" && name != "destination" {
+				return fmt.Errorf("tag %s: unknown template variable {%s}", key, name)
+			}
+		}
+	}
+
+	if o.ObjectLockMode, err = s3Enum("object_lock_mode", o.ObjectLockMode, s3.ObjectLockMode_Values()); err != nil {
+		return err
+	}
+	if o.retention, err = parseAge(o.ObjectLockRetention); err != nil {
+		return fmt.Errorf("object_lock_retention: %w", err)
+	}
+	if (o.ObjectLockMode == "") != (o.retention <= 0) {
+		return fmt.Errorf("object_lock_mode and object_lock_retention must be set together")
+	}
+	return nil
+}
+
+// s3Enum matches value case-insensitively against the values S3 accepts
+func s3Enum(name, value string, allowed []string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	for _, v := range allowed {
+		if strings.EqualFold(v, value) {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("%s %q is not one of %s", name, value, strings.Join(allowed, ", "))
+}
+
+// merge overlays the settings made in override. A tag set to "" in the
+// override removes the default tag.
+func (o DestinationOptions) merge(override *DestinationOptions) DestinationOptions {
+	out := o
+	out.Tags = make(map[string]string, len(o.Tags)+len(override.Tags))
+	for key, value := range o.Tags {
+		out.Tags[key] = value
+	}
+	for key, value := range override.Tags {
+		if value == "" {
+			delete(out.Tags, key)
+		} else {
+			out.Tags[key] = value
+		}
+	}
+	for _, f := range []struct{ dst, src *string }{
+		{&out.StorageClass, &override.StorageClass},
+		{&out.SSE, &override.SSE},
+		{&out.KMSKeyID, &override.KMSKeyID},
+		{&out.ACL, &override.ACL},
+		{&out.ObjectLockMode, &override.ObjectLockMode},
+		{&out.ObjectLockRetention, &override.ObjectLockRetention},
+	} {
+		if *f.src != "" {
+			*f.dst = *f.src
+		}
+	}
+	return out
+}
+
+// IsZero reports whether the options leave everything to the bucket
+func (o *DestinationOptions) IsZero() bool {
+	return o.StorageClass == "" && o.SSE == "" && o.KMSKeyID == "" && o.ACL == "" &&
+		len(o.Tags) == 0 && o.ObjectLockMode == "" && o.ObjectLockRetention == ""
+}
+
+// RetainUntil is when an object written at now leaves object lock
+func (o *DestinationOptions) RetainUntil(now time.Time) (time.Time, error) {
+	retention, err := parseAge(o.ObjectLockRetention)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return now.Add(retention), nil
+}
+
+// Tagging encodes the tags for the x-amz-tagging header
+func (o *DestinationOptions) Tagging() string {
+	values := url.Values{}
+	for key, value := range o.Tags {
+		values.Set(key, value)
+	}
+	return values.Encode()
+}
+
+// String describes the options for logs, e.g. "storage class STANDARD_IA,
+// sse aws:kms (alias/recordings), tags date,port"
+func (o *DestinationOptions) String() string {
+	if o == nil || o.IsZero() {
+		return "bucket defaults"
+	}
+	var parts []string
+	if o.StorageClass != "" {
+		parts = append(parts, "storage class "+o.StorageClass)
+	}
+	if o.SSE != "" {
+		sse := "sse " + o.SSE
+		if o.KMSKeyID != "" {
+			sse += " (" + o.KMSKeyID + ")"
+		}
+		parts = append(parts, sse)
+	}
+	if o.ACL != "" {
+		parts = append(parts, "acl "+o.ACL)
+	}
+	if len(o.Tags) > 0 {
+		keys := make([]string, 0, len(o.Tags))
+		for key := range o.Tags {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		parts = append(parts, "tags "+strings.Join(keys, ","))
+	}
+	if o.ObjectLockMode != "" {
+		parts = append(parts, fmt.Sprintf("object lock %s %s", o.ObjectLockMode, o.ObjectLockRetention))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// DestinationOptions resolves the write options for a source object: the
+// configured defaults, overridden by the first key rule that applies, with
+// the tag templates rendered. It returns nil when nothing is set.
+func (c *Config) DestinationOptions(source, destination string, date time.Time) *DestinationOptions {
+	opts := c.S3Options
+	for i := range c.KeyRules {
+		if rule := &c.KeyRules[i]; rule.applies(source) {
+			if rule.options != nil {
+				opts = *rule.options
+			}
+			break
+		}
+	}
+	if opts.IsZero() {
+		return nil
+	}
+
+	if len(opts.Tags) > 0 {
+		vars := pathVars(source, date)
+		vars["source"] = source
+		vars["destination"] = destination
+		tags := make(map[string]string, len(opts.Tags))
+		for key, value := range opts.Tags {
+			tags[key] = templateVar.ReplaceAllStringFunc(value, func(v string) string {
+				return vars[v[1:len(v)-1]]
+			})
+		}
+		opts.Tags = tags
+	}
+	return &opts
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Filter selects objects. The predicates set in one filter must all hold;
+// a list predicate holds when any of its entries matches. All and Any
+// combine nested filters, Not negates one.
+//
+//	{"any": [{"glob": ["**/*.mp4"]}, {"content_type": ["video/"]}],
+//	 "min_size": "1MB", "not": {"metadata": {"status": "draft"}}}
+type Filter struct {
+	// Glob patterns on the key: * and ? stay within a path segment, **
+	// crosses segments
+	Glob []string `json:"glob,omitempty"`
+	// Regular expressions on the key
+	Regex []string `json:"regex,omitempty"`
+	// Extensions of the key, e.g. ".mp4", case insensitive
+	Extension []string `json:"extension,omitempty"`
+	// Size bounds, inclusive, e.g. "500KB", "2GiB" or bytes
+	MinSize string `json:"min_size,omitempty"`
+	MaxSize string `json:"max_size,omitempty"`
+	// Content types, an entry ending in / matches the whole family
+	ContentType []string `json:"content_type,omitempty"`
+	// Storage classes, e.g. STANDARD, NEARLINE
+	StorageClass []string `json:"storage_class,omitempty"`
+	// Metadata values by key. "*" matches any value, a value starting
+	// with ~ is a regular expression.
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Age bounds from creation time, e.g. "12h", "30d", "2w"
+	MinAge string `json:"min_age,omitempty"`
+	MaxAge string `json:"max_age,omitempty"`
+
+	All []Filter `json:"all,omitempty"`
+	Any []Filter `json:"any,omitempty"`
+	Not *Filter  `json:"not,omitempty"`
+
+	keys             []*regexp.Regexp
+	minSize, maxSize int64
+	minAge, maxAge   time.Duration
+	metadata         map[string]*regexp.Regexp
+}
+
+// compile checks the filter and everything nested in it
+func (f *Filter) compile() error {
+	for _, glob := range f.Glob {
+		re, err := globRegexp(glob)
+		if err != nil {
+			return fmt.Errorf("invalid glob %q: %w", glob, err)
+		}
+		f.keys = append(f.keys, re)
+	}
+	for _, expr := range f.Regex {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return fmt.Errorf("invalid regex %q: %w", expr, err)
+		}
+		f.keys = append(f.keys, re)
+	}
+
+	var err error
+	if f.minSize, err = parseSize(f.MinSize, 0); err != nil {
+		return fmt.Errorf("min_size: %w", err)
+	}
+	if f.maxSize, err = parseSize(f.MaxSize, math.MaxInt64); err != nil {
+		return fmt.Errorf("max_size: %w", err)
+	}
+	if f.minAge, err = parseAge(f.MinAge); err != nil {
+		return fmt.Errorf("min_age: %w", err)
+	}
+	if f.maxAge, err = parseAge(f.MaxAge); err != nil {
+		return fmt.Errorf("max_age: %w", err)
+	}
+
+	f.metadata = make(map[string]*regexp.Regexp)
+	for key, value := range f.Metadata {
+		if expr, ok := strings.CutPrefix(value, "~"); ok {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return fmt.Errorf("metadata %s: %w", key, err)
+			}
+			f.metadata[strings.ToLower(key)] = re
+		}
+	}
+
+	for i := range f.All {
+		if err := f.All[i].compile(); err != nil {
+			return fmt.Errorf("all[%d]: %w", i, err)
+		}
+	}
+	for i := range f.Any {
+		if err := f.Any[i].compile(); err != nil {
+			return fmt.Errorf("any[%d]: %w", i, err)
+		}
+	}
+	if f.Not != nil {
+		if err := f.Not.compile(); err != nil {
+			return fmt.Errorf("not: %w", err)
+		}
+	}
+	return nil
+}
+
+// Match reports whether info passes the filter. Age is measured at now.
+func (f *Filter) Match(info *ObjectInfo, now time.Time) bool {
+	if len(f.keys) > 0 && !anyRegexp(f.keys, info.Key) {
+		return false
+	}
+	if len(f.Extension) > 0 && !containsFold(f.Extension, path.Ext(info.Key)) {
+		return false
+	}
+	if info.Size < f.minSize || info.Size > f.maxSize {
+		return false
+	}
+	if len(f.ContentType) > 0 && !matchContentType(f.ContentType, info.ContentType) {
+		return false
+	}
+	if len(f.StorageClass) > 0 && !containsFold(f.StorageClass, info.StorageClass) {
+		return false
+	}
+	for key, want := range f.Metadata {
+		value, ok := lookupFold(info.Metadata, key)
+		switch re := f.metadata[strings.ToLower(key)]; {
+		case !ok:
+			return false
+		case re != nil:
+			if !re.MatchString(value) {
+				return false
+			}
+		case want != "*" && value != want:
+			return false
+		}
+	}
+	if f.minAge > 0 || f.maxAge > 0 {
+		created := info.Created
+		if created.IsZero() {
+			created = info.Updated
+		}
+		age := now.Sub(created)
+		if age < f.minAge || (f.maxAge > 0 && age > f.maxAge) {
+			return false
+		}
+	}
+
+	for i := range f.All {
+		if !f.All[i].Match(info, now) {
+			return false
+		}
+	}
+	if len(f.Any) > 0 {
+		matched := false
+		for i := range f.Any {
+			if f.Any[i].Match(info, now) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if f.Not != nil && f.Not.Match(info, now) {
+		return false
+	}
+	return true
+}
+
+// Object attributes some listings leave out, see Config.FilterAttributes
+const (
+	attrContentType  = "content_type"
+	attrStorageClass = "storage_class"
+	attrMetadata     = "metadata"
+)
+
+// attributes adds the object attributes the filter's predicates read,
+// nested filters included, to used
+func (f *Filter) attributes(used map[string]bool) {
+	if len(f.ContentType) > 0 {
+		used[attrContentType] = true
+	}
+	if len(f.StorageClass) > 0 {
+		used[attrStorageClass] = true
+	}
+	if len(f.Metadata) > 0 {
+		used[attrMetadata] = true
+	}
+	for i := range f.All {
+		f.All[i].attributes(used)
+	}
+	for i := range f.Any {
+		f.Any[i].attributes(used)
+	}
+	if f.Not != nil {
+		f.Not.attributes(used)
+	}
+}
+
+// FilterAttributes is which of content_type, storage_class and metadata
+// the include and exclude filters read. GCS listings have all three, S3
+// listings only the storage class, inventories and local stores none.
+func (c *Config) FilterAttributes() map[string]bool {
+	used := make(map[string]bool)
+	if c.Include != nil {
+		c.Include.attributes(used)
+	}
+	if c.Exclude != nil {
+		c.Exclude.attributes(used)
+	}
+	return used
+}
+
+// Selected applies the include and exclude filters, either may be unset
+func (c *Config) Selected(info *ObjectInfo, now time.Time) bool {
+	if c.Include != nil && !c.Include.Match(info, now) {
+		return false
+	}
+	return c.Exclude == nil || !c.Exclude.Match(info, now)
+}
+
+// globRegexp turns a glob into an anchored regular expression
+func globRegexp(glob string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+				// "**/" also matches no directory at all
+				if i+1 < len(glob) && glob[i+1] == '/' {
+					i++
+					b.WriteString("(?:.*/)?")
+				} else {
+					b.WriteString(".*")
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end < 0 {
+				return nil, errors.New("unterminated [")
+			}
+			class := glob[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+func anyRegexp(res []*regexp.Regexp, s string) bool {
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchContentType(patterns []string, contentType string) bool {
+	// Drop parameters such as "; charset=utf-8"
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+		if mediaType == p || (strings.HasSuffix(p, "/") && strings.HasPrefix(mediaType, p)) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func lookupFold(m map[string]string, key string) (string, bool) {
+	if v, ok := m[key]; ok {
+		return v, true
+	}
+	for k, v := range m {
+		if strings.EqualFold(k, key) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+var sizeUnits = map[string]float64{
+	"": 1, "b": 1,
+	"kb": 1e3, "mb": 1e6, "gb": 1e9, "tb": 1e12,
+	"kib": 1 << 10, "mib": 1 << 20, "gib": 1 << 30, "tib": 1 << 40,
+}
+
+var sizeExpr = regexp.MustCompile(`^([0-9]*\.?[0-9]+)\s*([a-zA-Z]*)$`)
+
+// parseSize reads a byte count such as "500KB", "1.5GiB" or "1024". KB,
+// MB, ... are decimal, KiB, MiB, ... binary. Empty means def.
+func parseSize(value string, def int64) (int64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return def, nil
+	}
+	m := sizeExpr.FindStringSubmatch(value)
+	if m == nil {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	unit, ok := sizeUnits[strings.ToLower(m[2])]
+	if !ok {
+		return 0, fmt.Errorf("unknown size unit %q", m[2])
+	}
+	n, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return int64(n * unit), nil
+}
+
+// parseAge reads a duration that may also use days and weeks, e.g. "30d"
+func parseAge(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(value, suffix); ok {
+			f, err := strconv.ParseFloat(n, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid age %q", value)
+			}
+			return time.Duration(f * float64(unit)), nil
+		}
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid age %q", value)
+	}
+	return d, nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGlobRegexp(t *testing.T) {
+	tests := []struct {
+		glob  string
+		key   string
+		match bool
+	}{
+		{"*.mp4", "a.mp4", true},
+		{"*.mp4", "dir/a.mp4", false},
+		{"**/*.mp4", "a.mp4", true},
+		{"**/*.mp4", "cam1/2025-09-10/a.mp4", true},
+		{"**/*.mp4", "cam1/2025-09-10/a.mov", false},
+		{"cam1/**", "cam1/2025-09-10/a.mp4", true},
+		{"cam1/**", "cam2/a.mp4", false},
+		{"cam?/*", "cam1/a.mp4", true},
+		{"cam?/*", "cam10/a.mp4", false},
+		{"cam[12]/*", "cam2/a.mp4", true},
+		{"cam[!12]/*", "cam2/a.mp4", false},
+		{"cam[!12]/*", "cam3/a.mp4", true},
+		{"a+b.mp4", "a+b.mp4", true},
+		{"a.mp4", "axmp4", false},
+	}
+	for _, tt := range tests {
+		re, err := globRegexp(tt.glob)
+		if err != nil {
+			t.Fatalf("globRegexp(%q): %v", tt.glob, err)
+		}
+		if got := re.MatchString(tt.key); got != tt.match {
+			t.Errorf("glob %q on %q = %v, want %v", tt.glob, tt.key, got, tt.match)
+		}
+	}
+
+	if _, err := globRegexp("cam[12/*"); err == nil {
+		t.Error("unterminated [ was accepted")
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+	}{
+		{"", 7},
+		{"1024", 1024},
+		{"500KB", 500e3},
+		{"1.5GiB", 3 << 29},
+		{"10 MiB", 10 << 20},
+		{"2mb", 2e6},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.value, 7)
+		if err != nil {
+			t.Errorf("parseSize(%q): %v", tt.value, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseSize(%q) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+	for _, bad := range []string{"MB", "1.2.3", "5 parsecs"} {
+		if _, err := parseSize(bad, 0); err == nil {
+			t.Errorf("parseSize(%q) was accepted", bad)
+		}
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	now := time.Date(2025, 9, 15, 12, 0, 0, 0, time.UTC)
+	info := &ObjectInfo{
+		Key:           "cam1/2025-09-10/a.mp4",
+		Size:          2e6,
+		ObjectHeaders: ObjectHeaders{ContentType: "video/mp4; codecs=avc1"},
+		StorageClass:  "STANDARD",
+		Metadata:      map[string]string{"Status": "final"},
+		Created:       now.Add(-48 * time.Hour),
+	}
+	tests := []struct {
+		name   string
+		filter Filter
+		match  bool
+	}{
+		{"empty", Filter{}, true},
+		{"glob", Filter{Glob: []string{"**/*.mp4"}}, true},
+		{"extension", Filter{Extension: []string{".MOV"}}, false},
+		{"min size", Filter{MinSize: "1MB"}, true},
+		{"max size", Filter{MaxSize: "1MB"}, false},
+		{"content type family", Filter{ContentType: []string{"video/"}}, true},
+		{"content type", Filter{ContentType: []string{"video/webm"}}, false},
+		{"storage class", Filter{StorageClass: []string{"standard"}}, true},
+		{"metadata any value", Filter{Metadata: map[string]string{"status": "*"}}, true},
+		{"metadata regexp", Filter{Metadata: map[string]string{"status": "~^draft"}}, false},
+		{"metadata missing", Filter{Metadata: map[string]string{"owner": "*"}}, false},
+		{"min age", Filter{MinAge: "1d"}, true},
+		{"max age", Filter{MaxAge: "1d"}, false},
+		{"any", Filter{Any: []Filter{{Extension: []string{".mov"}}, {MinSize: "1MB"}}}, true},
+		{"all", Filter{All: []Filter{{Extension: []string{".mp4"}}, {MaxSize: "1MB"}}}, false},
+		{"not", Filter{Not: &Filter{Glob: []string{"cam2/**"}}}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.filter.compile(); err != nil {
+			t.Fatalf("%s: compile: %v", tt.name, err)
+		}
+		if got := tt.filter.Match(info, now); got != tt.match {
+			t.Errorf("%s: Match = %v, want %v", tt.name, got, tt.match)
+		}
+	}
+}
+
+func TestConfigIncludeExclude(t *testing.T) {
+	// Videos and their sidecars from port1, but no thumbnails and nothing
+	// marked as a draft
+	path := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(path, []byte(`{
+		"include": {"any": [{"extension": [".mp4"]}, {"glob": ["port1/**/*.json"]}]},
+		"exclude": {"any": [{"regex": ["_thumb\\."]}, {"metadata": {"status": "draft"}}]}
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadConfig(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	tests := []struct {
+		info     ObjectInfo
+		selected bool
+	}{
+		{ObjectInfo{Key: "port1/2025-09-10/a.mp4"}, true},
+		{ObjectInfo{Key: "port1/2025-09-10/a.json"}, true},
+		{ObjectInfo{Key: "port2/2025-09-10/a.json"}, false},
+		{ObjectInfo{Key: "port1/2025-09-10/a_thumb.mp4"}, false},
+		{ObjectInfo{Key: "port1/2025-09-10/b.mp4", Metadata: map[string]string{"status": "draft"}}, false},
+	}
+	for _, tt := range tests {
+		if got := config.Selected(&tt.info, now); got != tt.selected {
+			t.Errorf("Selected(%s) = %v, want %v", tt.info.Key, got, tt.selected)
+		}
+	}
+
+	os.WriteFile(path, []byte(`{"include": {"glob": ["port1/[ab"]}}`), 0644)
+	if _, err := LoadConfig(path, nil); err == nil {
+		t.Error("invalid glob was accepted")
+	}
+}
+
+// slowStatStore answers Stat slowly and records how many calls overlap
+type slowStatStore struct {
+	ObjectStore
+	running, most atomic.Int32
+}
+
+func (s *slowStatStore) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	n := s.running.Add(1)
+	defer s.running.Add(-1)
+	for {
+		most := s.most.Load()
+		if n <= most || s.most.CompareAndSwap(most, n) {
+			break
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	if key == "gone.mp4" {
+		return nil, errObjectNotFound
+	}
+	return &ObjectInfo{Key: key, ObjectHeaders: ObjectHeaders{ContentType: "video/mp4"}}, nil
+}
+
+func TestStatEachParallel(t *testing.T) {
+	src := &slowStatStore{}
+	list := func(ctx context.Context, fn func(*ObjectInfo) error) error {
+		for i := 0; i < 40; i++ {
+			if err := fn(&ObjectInfo{Key: fmt.Sprintf("port1/%02d.mp4", i)}); err != nil {
+				return err
+			}
+		}
+		return fn(&ObjectInfo{Key: "gone.mp4"})
+	}
+
+	var inFn sync.Mutex
+	seen := map[string]bool{}
+	err := statEach(context.Background(), src, 4, list, func(info *ObjectInfo) error {
+		if !inFn.TryLock() {
+			t.Error("fn called concurrently")
+			return nil
+		}
+		defer inFn.Unlock()
+		if info.ContentType == "" {
+			t.Errorf("%s passed on without its attributes", info.Key)
+		}
+		seen[info.Key] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 40 || seen["gone.mp4"] {
+		t.Errorf("fn saw %d objects, want the 40 that still exist", len(seen))
+	}
+	if most := src.most.Load(); most < 2 || most > 4 {
+		t.Errorf("%d Stat calls at once, want 2 to 4", most)
+	}
+
+	// The first error from fn stops the listing
+	calls := 0
+	err = statEach(context.Background(), src, 4, list, func(info *ObjectInfo) error {
+		calls++
+		return errInterrupted
+	})
+	if err != errInterrupted {
+		t.Errorf("got %v, want the error from fn", err)
+	}
+	if calls != 1 {
+		t.Errorf("fn called %d times after failing, want 1", calls)
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JobConfig is one entry of the "jobs" list in a config file. Its
+// settings are any config settings, applied over the top level of the
+// file, which holds what the jobs share:
+//
+//	{"log_file": "logs/migrate.log", "max_workers": 40, "cutoff_date": "last 7d",
+//	 "jobs": [
+//	   {"name": "cams-east", "gcs_bucket": "cams-east", "s3_bucket": "archive-east"},
+//	   {"name": "cams-west", "gcs_bucket": "cams-west", "s3_bucket": "archive-west",
+//	    "key_rules": [{"match": "^(?P<port>[^/]+)/", "template": "west/{port}/{date}/{name}"}]}
+//	 ]}
+//
+// Logging, the status server, limits, the shutdown grace period,
+// max_workers and the adaptive concurrency settings are shared by all jobs
+// and only read from the top level.
+// Environment variables and flags override the settings of every job.
+type JobConfig struct {
+	Name     string
+	Settings json.RawMessage
+}
+
+func (j *JobConfig) UnmarshalJSON(data []byte) error {
+	var named struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(data, &named); err != nil {
+		return err
+	}
+	j.Name = named.Name
+	j.Settings = append(json.RawMessage(nil), data...)
+	return nil
+}
+
+var jobName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// readJobs reads the jobs list of a config file, nil when it has none
+func readJobs(configPath string) ([]JobConfig, error) {
+	if configPath == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	var file struct {
+		Jobs []JobConfig `json:"jobs"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	seen := make(map[string]bool)
+	for i, job := range file.Jobs {
+		if !jobName.MatchString(job.Name) {
+			return nil, fmt.Errorf("jobs[%d]: name %q must be letters, digits, '.', '_' or '-'", i, job.Name)
+		}
+		if seen[job.Name] {
+			return nil, fmt.Errorf("jobs[%d]: duplicate job name %q", i, job.Name)
+		}
+		seen[job.Name] = true
+	}
+	return file.Jobs, nil
+}
+
+// LoadJobs builds the configuration of every job in the config file, or
+// of the selected ones. A config file without jobs gives base alone.
+func LoadJobs(configPath string, base *Config, overrides map[string]string, selected []string) ([]*Config, error) {
+	jobs, err := readJobs(configPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		if len(selected) > 0 {
+			return nil, fmt.Errorf("--job given, but the config file has no jobs")
+		}
+		return []*Config{base}, nil
+	}
+
+	// Every job is loaded, so selected jobs are checked against the rest
+	configs := make([]*Config, 0, len(jobs))
+	journals := make(map[string]string)
+	for i := range jobs {
+		config, err := loadConfig(configPath, &jobs[i], overrides)
+		if err != nil {
+			return nil, fmt.Errorf("job %s: %w", jobs[i].Name, err)
+		}
+		// Journals key objects by source key, so jobs cannot share one
+		journal, _ := filepath.Abs(config.JournalFile)
+		if other, ok := journals[journal]; ok {
+			return nil, fmt.Errorf("jobs %s and %s both use journal %s, give them their own journal_file", other, config.Job, config.JournalFile)
+		}
+		journals[journal] = config.Job
+		configs = append(configs, config)
+	}
+	if err := checkSyncScopes(configs); err != nil {
+		return nil, err
+	}
+	if len(selected) == 0 {
+		return configs, nil
+	}
+
+	byName := make(map[string]*Config, len(configs))
+	names := make([]string, 0, len(configs))
+	for _, config := range configs {
+		byName[config.Job] = config
+		names = append(names, config.Job)
+	}
+	var picked []*Config
+	for _, name := range selected {
+		config, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown job %q (jobs: %s)", name, strings.Join(names, ", "))
+		}
+		picked = append(picked, config)
+	}
+	return picked, nil
+}
+
+// checkSyncScopes refuses a job whose sync_delete scope overlaps what
+// another job writes to the same destination, as each would delete the
+// other's objects. A job whose key rules do not pin down a prefix may
+// write anywhere.
+func checkSyncScopes(configs []*Config) error {
+	for _, config := range configs {
+		if !config.SyncDelete {
+			continue
+		}
+		_, dst, _ := config.StoreURIs()
+		for _, other := range configs {
+			if other == config {
+				continue
+			}
+			if _, otherDst, _ := other.StoreURIs(); otherDst != dst {
+				continue
+			}
+			written, _ := other.writeScope()
+			if strings.HasPrefix(config.SyncDeleteScope, written) || strings.HasPrefix(written, config.SyncDeleteScope) {
+				return fmt.Errorf("job %s deletes extras under %q of %s, where job %s writes too; give them separate prefixes",
+					config.Job, config.SyncDeleteScope, dst, other.Job)
+			}
+		}
+	}
+	return nil
+}
+
+// SummaryDiff is the part of Summary that differs from base
+func (c *Config) SummaryDiff(base *Config) []string {
+	shared := make(map[string]bool)
+	for _, line := range base.Summary() {
+		shared[line] = true
+	}
+	var lines []string
+	for _, line := range c.Summary() {
+		if !shared[line] {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// jobPath inserts a job name before the extension of a per-job output
+// file, e.g. migration_manifest.jsonl becomes
+// migration_manifest_cams-east.jsonl. Without a job it is path itself.
+func jobPath(path, job string) string {
+	if job == "" {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "_" + job + ext
+}
+
+// jobLogger tags the events of a job with its name
+func jobLogger(logger *TimestampLogger, config *Config) *TimestampLogger {
+	if config.Job == "" {
+		return logger
+	}
+	return logger.With("job", config.Job)
+}
+
+// jobResult is how one job of a multi-job command ended
+type jobResult struct {
+	name     string
+	err      error
+	duration time.Duration
+}
+
+// runJobs runs a command for every job, all at once when parallel is set
+// and one after the other otherwise, then sums up how each job went
+func runJobs(
+	ctx context.Context,
+	configs []*Config,
+	logger *TimestampLogger,
+	parallel bool,
+	run func(ctx context.Context, config *Config, logger *TimestampLogger) error,
+) error {
+	names := make([]string, len(configs))
+	for i, config := range configs {
+		names[i] = config.Job
+	}
+	mode := "one after the other"
+	if parallel {
+		mode = "in parallel"
+	}
+	logger.Log("Running %d jobs %s: %s", len(configs), mode, strings.Join(names, ", "))
+	logger.Log("")
+
+	results := make([]jobResult, len(configs))
+	runOne := func(i int) {
+		config := configs[i]
+		started := time.Now()
+		err := run(ctx, config, jobLogger(logger, config))
+		results[i] = jobResult{name: config.Job, err: err, duration: time.Since(started)}
+	}
+	if parallel {
+		var wg sync.WaitGroup
+		for i := range configs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				runOne(i)
+			}()
+		}
+		wg.Wait()
+	} else {
+		for i := range configs {
+			if shutdown.Requested() {
+				results[i] = jobResult{name: configs[i].Job, err: errInterrupted}
+				continue
+			}
+			runOne(i)
+		}
+	}
+
+	return summarizeJobs(results, logger)
+}
+
+// summarizeJobs logs a line per job with its stats, when it ran a
+// migration, and returns an error when any job failed
+func summarizeJobs(results []jobResult, logger *TimestampLogger) error {
+	var buf bytes.Buffer
+	logger.Log("")
+	logger.Log("=== Jobs ===")
+	failed, interrupted := 0, 0
+	for _, r := range results {
+		buf.Reset()
+		mark, status := "✓", "complete"
+		switch {
+		case errors.Is(r.err, errInterrupted):
+			mark, status = "⊘", "interrupted"
+			interrupted++
+		case r.err != nil:
+			mark, status = "✗", r.err.Error()
+			failed++
+		}
+		fmt.Fprintf(&buf, "  %s %-20s %-12s", mark, r.name, r.duration.Round(time.Second))
+		if stats := activeStats.Get(r.name); stats != nil {
+			fmt.Fprintf(&buf, " copied %d (%s), skipped %d, failed %d,",
+				stats.copiedFiles.Load(), formatBytes(stats.copiedBytes.Load()), stats.skippedExisting.Load(),
+				stats.errorFiles.Load()+stats.checksumErrors.Load())
+		}
+		fmt.Fprintf(&buf, " %s", status)
+		logger.Log("%s", buf.String())
+	}
+
+	if interrupted > 0 {
+		return errInterrupted
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d jobs failed", failed, len(results))
+	}
+	return nil
+}
+
+// statsRegistry holds the stats of the migrations the status server
+// reports on, by job name ("" when the config has no jobs)
+type statsRegistry struct {
+	mu   sync.Mutex
+	runs map[string]*Stats
+}
+
+func (r *statsRegistry) Store(job string, stats *Stats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.runs == nil {
+		r.runs = make(map[string]*Stats)
+	}
+	r.runs[job] = stats
+}
+
+func (r *statsRegistry) Get(job string) *Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.runs[job]
+}
+
+// All returns the job names and their stats, ordered by name
+func (r *statsRegistry) All() ([]string, []*Stats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, 0, len(r.runs))
+	for name := range r.runs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	stats := make([]*Stats, len(names))
+	for i, name := range names {
+		stats[i] = r.runs[name]
+	}
+	return names, stats
+}
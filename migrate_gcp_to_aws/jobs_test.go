@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func loadTestJobs(t *testing.T, file string, selected ...string) ([]*Config, error) {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	file = strings.ReplaceAll(file, "$LOGS", filepath.Join(dir, "logs"))
+	if err := os.WriteFile(path, []byte(file), 0644); err != nil {
+		t.Fatal(err)
+	}
+	base, err := LoadConfig(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return LoadJobs(path, base, nil, selected)
+}
+
+func TestLoadJobs(t *testing.T) {
+	configs, err := loadTestJobs(t, `{
+		"log_file": "$LOGS/migrate.log", "cutoff_date": "2025-09-01", "max_workers": 8,
+		"jobs": [
+			{"name": "east", "source": "gs://cams-east", "destination": "s3://archive"},
+			{"name": "west", "source": "gs://cams-west", "destination": "s3://archive",
+			 "cutoff_date": "2025-09-07", "key_rules": [{"add_prefix": "west/"}]}
+		]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 2 {
+		t.Fatalf("%d jobs, want 2", len(configs))
+	}
+	east, west := configs[0], configs[1]
+	if east.Job != "east" || east.CutoffDateStr != "2025-09-01" || east.MaxWorkers != 8 {
+		t.Errorf("east does not have the shared settings: %+v", east)
+	}
+	if west.CutoffDateStr != "2025-09-07" || len(west.KeyRules) != 1 {
+		t.Errorf("west does not have its own settings: %+v", west)
+	}
+	if east.JournalFile == west.JournalFile {
+		t.Errorf("jobs share the journal %s", east.JournalFile)
+	}
+
+	configs, err = loadTestJobs(t, `{"log_file": "$LOGS/migrate.log",
+		"jobs": [{"name": "east", "source": "gs://a"}, {"name": "west", "source": "gs://b"}]}`, "west")
+	if err != nil || len(configs) != 1 || configs[0].Job != "west" {
+		t.Errorf("selecting west gave %d jobs, %v", len(configs), err)
+	}
+	if _, err := loadTestJobs(t, `{"log_file": "$LOGS/migrate.log", "jobs": [{"name": "east"}]}`, "north"); err == nil {
+		t.Error("unknown job was selected")
+	}
+}
+
+func TestLoadJobsSyncDeleteOverlap(t *testing.T) {
+	tests := []struct {
+		name string
+		jobs string
+		ok   bool
+	}{
+		{"separate prefixes", `
+			{"name": "east", "source": "gs://east", "sync": true, "sync_delete": true, "key_rules": [{"add_prefix": "east/"}]},
+			{"name": "west", "source": "gs://west", "key_rules": [{"add_prefix": "west/"}]}`, true},
+		{"other job writes everywhere", `
+			{"name": "east", "source": "gs://east", "sync": true, "sync_delete": true, "key_rules": [{"add_prefix": "east/"}]},
+			{"name": "west", "source": "gs://west"}`, false},
+		{"nested prefixes", `
+			{"name": "east", "source": "gs://east", "sync": true, "sync_delete": true, "key_rules": [{"add_prefix": "cams/"}]},
+			{"name": "west", "source": "gs://west", "key_rules": [{"add_prefix": "cams/west/"}]}`, false},
+		{"other destination", `
+			{"name": "east", "source": "gs://east", "sync": true, "sync_delete": true},
+			{"name": "west", "source": "gs://west", "destination": "s3://elsewhere"}`, true},
+	}
+	for _, tt := range tests {
+		_, err := loadTestJobs(t, `{"log_file": "$LOGS/migrate.log", "destination": "s3://archive", "jobs": [`+tt.jobs+`]}`)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: err %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+
+	// Selecting one job still checks it against the others
+	_, err := loadTestJobs(t, `{"log_file": "$LOGS/migrate.log", "destination": "s3://archive", "jobs": [
+		{"name": "east", "source": "gs://east", "sync": true, "sync_delete": true},
+		{"name": "west", "source": "gs://west"}]}`, "east")
+	if err == nil {
+		t.Error("overlap with an unselected job was accepted")
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// JournalState is the last known state of a FileJob
+type JournalState string
+
+const (
+	JournalQueued   JournalState = "queued"
+	JournalCopying  JournalState = "copying"
+	JournalCopied   JournalState = "copied"
+	JournalVerified JournalState = "verified"
+	JournalFailed   JournalState = "failed"
+	// JournalDeleted marks a verified object whose source was removed in
+	// move mode
+	JournalDeleted JournalState = "deleted"
+)
+
+// JournalEntry is one line of the journal file
+type JournalEntry struct {
+	Name        string       `json:"name"`
+	Generation  int64        `json:"generation"`
+	State       JournalState `json:"state"`
+	Size        int64        `json:"size,omitempty"`
+	Destination string       `json:"destination,omitempty"`
+	Date        string       `json:"date,omitempty"`
+	Reason      string       `json:"reason,omitempty"`
+	Time        time.Time    `json:"time"`
+}
+
+// Journal is an append-only log of per-object migration state. Every state
+// change is written as a JSON line, so after a crash the last line for each
+// object tells a restarted run what is already done.
+//
+// Only one process may write a journal at a time: a writer holds an
+// exclusive lock on <journal>.lock for as long as the journal is open
+// (flock on Unix, LockFileEx on Windows). Readers take no lock and never
+// rewrite the file.
+//
+// Verified and deleted records are fsynced before Record returns, since
+// move and sync decide what to delete from them.
+type Journal struct {
+	mu      sync.Mutex
+	f       *os.File
+	lock    *os.File
+	entries map[string]JournalEntry
+}
+
+func journalKey(name string, generation int64) string {
+	return fmt.Sprintf("%s#%d", name, generation)
+}
+
+// OpenJournal locks the journal, replays an existing journal file and
+// reopens it for appending. The file is compacted to one line per object
+// on open so it does not grow without bound across runs.
+func OpenJournal(path string) (*Journal, error) {
+	lock, err := lockJournal(path)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := replayJournal(path)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+
+	if err := compactJournal(path, entries); err != nil {
+		lock.Close()
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+
+	return &Journal{f: f, lock: lock, entries: entries}, nil
+}
+
+// ReadJournal replays a journal without locking or rewriting it, so it can
+// be read while a run is appending to it. The journal cannot be written.
+// A missing journal reads as empty.
+func ReadJournal(path string) (*Journal, error) {
+	entries, err := replayJournal(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{entries: entries}, nil
+}
+
+// errLockHeld is returned by lockFile when another process has the lock
+var errLockHeld = errors.New("lock held by another process")
+
+// lockJournal takes the writer lock of a journal. The lock goes away with
+// the process, so a killed run does not leave the journal locked.
+func lockJournal(path string) (*os.File, error) {
+	lock, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal lock: %w", err)
+	}
+	if err := lockFile(lock); err != nil {
+		lock.Close()
+		if errors.Is(err, errLockHeld) {
+			return nil, fmt.Errorf("journal %s is in use by another process", path)
+		}
+		return nil, fmt.Errorf("failed to lock journal: %w", err)
+	}
+	return lock, nil
+}
+
+// replayJournal reads the last entry of every object from a journal file
+func replayJournal(path string) (map[string]JournalEntry, error) {
+	entries := make(map[string]JournalEntry)
+
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var entry JournalEntry
+			// A torn last line from a killed run is expected, skip it
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				continue
+			}
+			entries[journalKey(entry.Name, entry.Generation)] = entry
+		}
+		err := scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read journal: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+	return entries, nil
+}
+
+// compactJournal rewrites the journal with the latest entry per object,
+// going through a temp file so a crash never leaves a half-written journal
+func compactJournal(path string, entries map[string]JournalEntry) error {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to compact journal: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, key := range keys {
+		if err := enc.Encode(entries[key]); err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to compact journal: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to compact journal: %w", err)
+	}
+	// The rename must not land before the data it points to
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to compact journal: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to compact journal: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to compact journal: %w", err)
+	}
+	return nil
+}
+
+// Record appends a state change for a job
+func (j *Journal) Record(job FileJob, state JournalState, reason string) error {
+	entry := JournalEntry{
+		Name:        job.GCSPath,
+		Generation:  job.Generation,
+		State:       state,
+		Size:        job.Size,
+		Destination: job.RelativePath,
+		Reason:      reason,
+		Time:        time.Now().UTC(),
+	}
+	if !job.CreatedTime.IsZero() {
+		entry.Date = job.CreatedTime.Format("2006-01-02")
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.f == nil {
+		return fmt.Errorf("journal was opened read-only")
+	}
+	j.entries[journalKey(entry.Name, entry.Generation)] = entry
+	if _, err := j.f.Write(line); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	if state == JournalVerified || state == JournalDeleted {
+		if err := j.f.Sync(); err != nil {
+			return fmt.Errorf("failed to sync journal: %w", err)
+		}
+	}
+	return nil
+}
+
+// Lookup returns the last recorded entry for an object generation
+func (j *Journal) Lookup(name string, generation int64) (JournalEntry, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entry, ok := j.entries[journalKey(name, generation)]
+	return entry, ok
+}
+
+// Entries returns the latest entry of every object, sorted by name
+func (j *Journal) Entries() []JournalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entries := make([]JournalEntry, 0, len(j.entries))
+	for _, entry := range j.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(a, b int) bool {
+		if entries[a].Name != entries[b].Name {
+			return entries[a].Name < entries[b].Name
+		}
+		return entries[a].Generation < entries[b].Generation
+	})
+	return entries
+}
+
+// ManifestEntry turns a journal entry into an approved copy, so earlier
+// work can be retried or verified through the manifest code paths
+func (e JournalEntry) ManifestEntry() ManifestEntry {
+	return ManifestEntry{
+		Source:      e.Name,
+		Generation:  e.Generation,
+		Destination: e.Destination,
+		Size:        e.Size,
+		Date:        e.Date,
+		Decision:    DecisionCopy,
+	}
+}
+
+// Counts returns the number of objects in each state
+func (j *Journal) Counts() map[JournalState]int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	counts := make(map[JournalState]int)
+	for _, entry := range j.entries {
+		counts[entry.State]++
+	}
+	return counts
+}
+
+// Close closes the journal file and then releases the writer lock
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.f == nil {
+		return nil
+	}
+	defer j.lock.Close()
+	if err := j.f.Sync(); err != nil {
+		j.f.Close()
+		return err
+	}
+	return j.f.Close()
+}
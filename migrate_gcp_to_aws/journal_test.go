@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJournalResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "migrate_journal.jsonl")
+
+	journal, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := FileJob{GCSPath: "port1/2025-09-10/a.mp4", RelativePath: "port1/2025-09-10/a.mp4", Generation: 1, Size: 10}
+	crashed := FileJob{GCSPath: "port1/2025-09-10/b.mp4", RelativePath: "port1/2025-09-10/b.mp4", Generation: 7, Size: 20}
+	for _, state := range []JournalState{JournalQueued, JournalCopying, JournalCopied, JournalVerified} {
+		if err := journal.Record(done, state, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, state := range []JournalState{JournalQueued, JournalCopying} {
+		if err := journal.Record(crashed, state, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := journal.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A killed run can leave half a line behind
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"name":"port1/2025-09-10/b.mp4","generation":7,"sta`)
+	f.Close()
+
+	journal, err = OpenJournal(path)
+	if err != nil {
+		t.Fatalf("reopening after a torn line: %v", err)
+	}
+	defer journal.Close()
+
+	if entry, ok := journal.Lookup(done.GCSPath, done.Generation); !ok || entry.State != JournalVerified {
+		t.Errorf("finished object: %+v, %v, want verified", entry, ok)
+	}
+	if entry, ok := journal.Lookup(crashed.GCSPath, crashed.Generation); !ok || entry.State != JournalCopying {
+		t.Errorf("interrupted object: %+v, %v, want copying", entry, ok)
+	}
+	// A rewritten source object is a new generation and copied again
+	if _, ok := journal.Lookup(done.GCSPath, done.Generation+1); ok {
+		t.Error("new generation found in the journal")
+	}
+
+	counts := journal.Counts()
+	if counts[JournalVerified] != 1 || counts[JournalCopying] != 1 || len(counts) != 2 {
+		t.Errorf("counts %v, want 1 verified and 1 copying", counts)
+	}
+
+	// Reopening compacts the file to the last state of each object
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(data, []byte("\n")); lines != 2 {
+		t.Errorf("compacted journal has %d lines, want 2:\n%s", lines, data)
+	}
+}
+
+func TestJournalFailureReason(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "migrate_journal.jsonl")
+	journal, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := FileJob{GCSPath: "port2/2025-09-11/c.mp4", Generation: 3}
+	if err := journal.Record(job, JournalFailed, "checksum mismatch"); err != nil {
+		t.Fatal(err)
+	}
+	journal.Close()
+
+	journal, err = OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+	entry, ok := journal.Lookup(job.GCSPath, job.Generation)
+	if !ok || entry.State != JournalFailed || entry.Reason != "checksum mismatch" {
+		t.Errorf("got %+v, %v, want failed with its reason", entry, ok)
+	}
+}
+
+func TestJournalLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "migrate_journal.jsonl")
+	journal, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := FileJob{GCSPath: "port1/2025-09-10/a.mp4", Generation: 1}
+	if err := journal.Record(job, JournalVerified, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if second, err := OpenJournal(path); err == nil {
+		second.Close()
+		t.Fatal("a second writer opened a locked journal")
+	}
+
+	// Readers do not need the lock and see what the writer appended
+	reader, err := ReadJournal(path)
+	if err != nil {
+		t.Fatalf("reading a locked journal: %v", err)
+	}
+	if entry, ok := reader.Lookup(job.GCSPath, job.Generation); !ok || entry.State != JournalVerified {
+		t.Errorf("reader got %+v, %v, want verified", entry, ok)
+	}
+
+	if err := journal.Close(); err != nil {
+		t.Fatal(err)
+	}
+	journal, err = OpenJournal(path)
+	if err != nil {
+		t.Fatalf("reopening after close: %v", err)
+	}
+	journal.Close()
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KeyRule rewrites source keys into destination keys. A rule applies to
+// keys that match Match (any key when empty) and start with StripPrefix.
+// Its steps run in field order: strip the prefix, replace Match with
+// Replace, render Template, add AddPrefix.
+//
+// Templates take {key}, {dir}, {basename}, {name} (basename without
+// extension), {ext}, {date}, {year}, {month}, {day}, {port} (first path
+// segment), {0}, {1}, ... (path segments) and the named groups of Match.
+//
+// S3Options override the configured destination options for the keys the
+// rule applies to.
+type KeyRule struct {
+	Match       string  `json:"match,omitempty"`
+	StripPrefix string  `json:"strip_prefix,omitempty"`
+	Replace     *string `json:"replace,omitempty"`
+	Template    string  `json:"template,omitempty"`
+	AddPrefix   string  `json:"add_prefix,omitempty"`
+
+	S3Options *DestinationOptions `json:"s3_options,omitempty"`
+
+	match   *regexp.Regexp
+	options *DestinationOptions
+}
+
+var templateVar = regexp.MustCompile(`\{([A-Za-z0-9_]+)\}`)
+
+var templateVars = map[string]bool{
+	"key": true, "dir": true, "basename": true, "name": true, "ext": true,
+	"date": true, "year": true, "month": true, "day": true, "port": true,
+}
+
+var dateVars = map[string]bool{"date": true, "year": true, "month": true, "day": true}
+
+// compile checks the rule and prepares its regexp. defaults are the
+// destination options the rule's own options are laid over.
+func (r *KeyRule) compile(defaults DestinationOptions) error {
+	if r.Match != "" {
+		re, err := regexp.Compile(r.Match)
+		if err != nil {
+			return fmt.Errorf("invalid match: %w", err)
+		}
+		r.match = re
+	}
+	if r.Replace != nil && r.match == nil {
+		return fmt.Errorf("replace needs a match")
+	}
+	for _, m := range templateVar.FindAllStringSubmatch(r.Template, -1) {
+		name := m[1]
+		if _, err := strconv.Atoi(name); err == nil || templateVars[name] {
+			continue
+		}
+		if r.match == nil || r.match.SubexpIndex(name) < 0 {
+			return fmt.Errorf("unknown template variable {%s}", name)
+		}
+	}
+	r.options = nil
+	if r.S3Options != nil {
+		options := defaults.merge(r.S3Options)
+		if err := options.compile(); err != nil {
+			return fmt.Errorf("s3_options: %w", err)
+		}
+		r.options = &options
+	}
+	return nil
+}
+
+// applies reports whether the rule rewrites key
+func (r *KeyRule) applies(key string) bool {
+	if !strings.HasPrefix(key, r.StripPrefix) {
+		return false
+	}
+	return r.match == nil || r.match.MatchString(key)
+}
+
+// apply rewrites key. date is the date extracted from the source object,
+// zero when there is none.
+func (r *KeyRule) apply(key string, date time.Time) (string, error) {
+	groups := map[string]string{}
+	if r.match != nil {
+		if m := r.match.FindStringSubmatch(key); m != nil {
+			for i, name := range r.match.SubexpNames() {
+				if name != "" {
+					groups[name] = m[i]
+				}
+			}
+		}
+	}
+
+	out := strings.TrimPrefix(key, r.StripPrefix)
+	if r.Replace != nil {
+		out = r.match.ReplaceAllString(out, *r.Replace)
+	}
+
+	if r.Template != "" {
+		segments := strings.Split(out, "/")
+		vars := pathVars(out, date)
+
+		var err error
+		out = templateVar.ReplaceAllStringFunc(r.Template, func(v string) string {
+			name := v[1 : len(v)-1]
+			if value, ok := vars[name]; ok {
+				return value
+			}
+			if value, ok := groups[name]; ok {
+				return value
+			}
+			if i, convErr := strconv.Atoi(name); convErr == nil && i < len(segments) {
+				return segments[i]
+			}
+			if dateVars[name] {
+				err = fmt.Errorf("template needs a date but %s has none", key)
+			} else if err == nil {
+				err = fmt.Errorf("template variable %s is empty for %s", v, key)
+			}
+			return ""
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	out = r.AddPrefix + out
+	if out == "" || strings.HasSuffix(out, "/") {
+		return "", fmt.Errorf("rule maps %s to invalid key %q", key, out)
+	}
+	return out, nil
+}
+
+// pathVars are the template variables of a key and its date. The date
+// variables are left out when date is zero.
+func pathVars(key string, date time.Time) map[string]string {
+	base := path.Base(key)
+	ext := path.Ext(base)
+	port, _, _ := strings.Cut(key, "/")
+	vars := map[string]string{
+		"key":      key,
+		"dir":      path.Dir(key),
+		"basename": base,
+		"name":     strings.TrimSuffix(base, ext),
+		"ext":      ext,
+		"port":     port,
+	}
+	if !date.IsZero() {
+		vars["date"] = date.Format("2006-01-02")
+		vars["year"] = date.Format("2006")
+		vars["month"] = date.Format("01")
+		vars["day"] = date.Format("02")
+	}
+	return vars
+}
+
+// DestinationKey maps a source key with the first rule that applies. Keys
+// no rule applies to keep their name.
+func (c *Config) DestinationKey(key string, date time.Time) (string, error) {
+	for i := range c.KeyRules {
+		if rule := &c.KeyRules[i]; rule.applies(key) {
+			return rule.apply(key, date)
+		}
+	}
+	return key, nil
+}
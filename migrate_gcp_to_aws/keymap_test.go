@@ -0,0 +1,138 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyRuleApply(t *testing.T) {
+	date := time.Date(2025, 9, 10, 0, 0, 0, 0, time.UTC)
+	replace := func(s string) *string { return &s }
+
+	tests := []struct {
+		name string
+		rule KeyRule
+		key  string
+		want string
+	}{
+		{"add prefix", KeyRule{AddPrefix: "recordings/"}, "port1/a.mp4", "recordings/port1/a.mp4"},
+		{"strip prefix", KeyRule{StripPrefix: "legacy/"}, "legacy/port1/a.mp4", "port1/a.mp4"},
+		{"strip and add", KeyRule{StripPrefix: "legacy/", AddPrefix: "recordings/"}, "legacy/a.mp4", "recordings/a.mp4"},
+		{"capture and replace", KeyRule{Match: `^(port\d+)/(\d+)-(\d+)-(\d+)/`, Replace: replace("$1/$2/$3/$4/")},
+			"port1/2025-09-10/a.mp4", "port1/2025/09/10/a.mp4"},
+		{"request example", KeyRule{Template: "recordings/{year}/{month}/{port}/{basename}"},
+			"port1/2025-09-10/a.mp4", "recordings/2025/09/port1/a.mp4"},
+		{"name and ext", KeyRule{Template: "{dir}/{name}-{date}{ext}"}, "port1/2025-09-10/a.mp4", "port1/2025-09-10/a-2025-09-10.mp4"},
+		{"path segments", KeyRule{Template: "{1}/{0}/{basename}"}, "port1/2025-09-10/a.mp4", "2025-09-10/port1/a.mp4"},
+		{"named group", KeyRule{Match: `_(?P<site>[a-z]+)\.mp4$`, Template: "{site}/{key}"},
+			"port1/a_dock.mp4", "dock/port1/a_dock.mp4"},
+		{"template then prefix", KeyRule{Template: "{date}/{basename}", AddPrefix: "archive/"},
+			"port1/a.mp4", "archive/2025-09-10/a.mp4"},
+	}
+	for _, tt := range tests {
+		if err := tt.rule.compile(DestinationOptions{}); err != nil {
+			t.Fatalf("%s: compile: %v", tt.name, err)
+		}
+		got, err := tt.rule.apply(tt.key, date)
+		if err != nil {
+			t.Errorf("%s: apply(%q): %v", tt.name, tt.key, err)
+		} else if got != tt.want {
+			t.Errorf("%s: apply(%q) = %q, want %q", tt.name, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestKeyRuleErrors(t *testing.T) {
+	for _, rule := range []KeyRule{
+		{Match: "("},
+		{Replace: new(string)},
+		{Template: "{site}/{key}"},
+	} {
+		if err := rule.compile(DestinationOptions{}); err == nil {
+			t.Errorf("compile(%+v) was accepted", rule)
+		}
+	}
+
+	tests := []struct {
+		name string
+		rule KeyRule
+	}{
+		{"template needs a date", KeyRule{Template: "{year}/{basename}"}},
+		{"missing segment", KeyRule{Template: "{5}/{basename}"}},
+		{"nothing left", KeyRule{StripPrefix: "port1/a.mp4"}},
+		{"directory key", KeyRule{Template: "{dir}/"}},
+	}
+	for _, tt := range tests {
+		if err := tt.rule.compile(DestinationOptions{}); err != nil {
+			t.Fatalf("%s: compile: %v", tt.name, err)
+		}
+		if got, err := tt.rule.apply("port1/a.mp4", time.Time{}); err == nil {
+			t.Errorf("%s: mapped to %q, want an error", tt.name, got)
+		}
+	}
+}
+
+func TestDestinationKeyFirstRuleWins(t *testing.T) {
+	config := &Config{KeyRules: []KeyRule{
+		{StripPrefix: "tmp/", Match: `\.part$`, AddPrefix: "partial/"},
+		{StripPrefix: "port2/", AddPrefix: "dock/"},
+		{StripPrefix: "port2/", AddPrefix: "never/"},
+	}}
+	for i := range config.KeyRules {
+		if err := config.KeyRules[i].compile(DestinationOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for key, want := range map[string]string{
+		"tmp/a.part":  "partial/a.part",
+		"tmp/a.mp4":   "tmp/a.mp4",
+		"port2/a.mp4": "dock/a.mp4",
+		"port1/a.mp4": "port1/a.mp4",
+	} {
+		if got, err := config.DestinationKey(key, time.Time{}); err != nil || got != want {
+			t.Errorf("DestinationKey(%q) = %q, %v, want %q", key, got, err, want)
+		}
+	}
+}
+
+func TestPlanKeyCollisions(t *testing.T) {
+	config, err := LoadConfig("", map[string]string{"cutoff_date": "2025-09-01"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Both ports land in the same folder
+	config.KeyRules = []KeyRule{{Template: "recordings/{date}/{basename}"}}
+	if err := config.KeyRules[0].compile(DestinationOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	claims := keyClaims{}
+	var decisions []string
+	for _, key := range []string{"port1/2025-09-10/a.mp4", "port2/2025-09-10/a.mp4", "port2/2025-09-10/b.mp4"} {
+		entry := planObject(&ObjectInfo{Key: key}, config)
+		if entry.Decision != DecisionCopy {
+			t.Fatalf("%s: %s (%s), want copy", key, entry.Decision, entry.Reason)
+		}
+		if other, ok := claims.claim(entry.Destination, entry.Source); !ok {
+			decisions = append(decisions, key+" collides with "+other)
+		}
+	}
+	if want := "port2/2025-09-10/a.mp4 collides with port1/2025-09-10/a.mp4"; strings.Join(decisions, "; ") != want {
+		t.Errorf("collisions %q, want %q", decisions, want)
+	}
+
+	// Claiming the same key again for the same source is not a collision
+	if _, ok := claims.claim("recordings/2025-09-10/a.mp4", "port1/2025-09-10/a.mp4"); !ok {
+		t.Error("same source collided with itself")
+	}
+
+	// A key the rules cannot produce is reported, not copied
+	config.KeyRules = []KeyRule{{Template: "{port}/{7}"}}
+	if err := config.KeyRules[0].compile(DestinationOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if entry := planObject(&ObjectInfo{Key: "port1/2025-09-10/a.mp4"}, config); entry.Decision != DecisionKeyError {
+		t.Errorf("unmappable key planned as %s", entry.Decision)
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// listSource calls fn for every object at the source: read from the
+// inventory file when one is configured, otherwise listed from src by up
+// to ListConcurrency listers. Attributes the filters need and the listing
+// lacks are read with up to ListConcurrency Stat calls at once. Either way
+// fn is never called concurrently.
+func listSource(ctx context.Context, config *Config, src ObjectStore, logger *TimestampLogger, fn func(*ObjectInfo) error) error {
+	list := func(ctx context.Context, fn func(*ObjectInfo) error) error {
+		if config.SourceInventory != "" {
+			logger.Log("Listing from inventory %s instead of %s", config.SourceInventory, src.URI())
+			return readInventory(ctx, config.SourceInventory, fn)
+		}
+		if config.ListConcurrency > 1 {
+			logger.Debug("Listing in parallel", "listers", config.ListConcurrency, "depth", config.ListShardDepth)
+		}
+		return listSharded(ctx, src, config.ListShardDepth, config.ListConcurrency, fn)
+	}
+	if needsStat(config, src) {
+		logger.Log("Filters need object attributes the listing does not have, reading them object by object")
+		return statEach(ctx, src, config.ListConcurrency, list, fn)
+	}
+	return list(ctx, fn)
+}
+
+// needsStat reports whether the filters read attributes the listing of
+// src leaves out: inventories have none of them, S3 listings only the
+// storage class
+func needsStat(config *Config, src ObjectStore) bool {
+	attrs := config.FilterAttributes()
+	switch {
+	case len(attrs) == 0:
+		return false
+	case config.SourceInventory != "":
+		return true
+	case strings.HasPrefix(src.URI(), "s3://"):
+		return attrs[attrContentType] || attrs[attrMetadata]
+	}
+	return false
+}
+
+// statEach calls fn with the full attributes of every object list gives,
+// reading them with up to workers Stat calls at a time. fn is never called
+// concurrently. Objects deleted since they were listed are left out.
+func statEach(ctx context.Context, src ObjectStore, workers int, list func(context.Context, func(*ObjectInfo) error) error, fn func(*ObjectInfo) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var errOnce sync.Once
+	var firstErr error
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	keys := make(chan string)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range keys {
+				// After a failure the rest of the keys are only drained
+				if ctx.Err() != nil {
+					continue
+				}
+				full, err := src.Stat(ctx, key)
+				if errors.Is(err, errObjectNotFound) {
+					continue
+				}
+				if err != nil {
+					fail(fmt.Errorf("reading attributes of %s: %w", key, err))
+					continue
+				}
+				mu.Lock()
+				if ctx.Err() == nil {
+					err = fn(full)
+				}
+				mu.Unlock()
+				if err != nil {
+					fail(err)
+				}
+			}
+		}()
+	}
+
+	err := list(ctx, func(info *ObjectInfo) error {
+		select {
+		case keys <- info.Key:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	close(keys)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return err
+}
+
+// listSharded lists everything in src. The first depth levels of "/"
+// prefixes (port folders, then date folders) are discovered with delimiter
+// queries and every prefix below them is listed on its own, at most
+// concurrency listings at a time. The first error stops the rest.
+func listSharded(ctx context.Context, src ObjectStore, depth, concurrency int, fn func(*ObjectInfo) error) error {
+	if concurrency <= 1 || depth <= 0 {
+		return src.List(ctx, "", fn)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var mu sync.Mutex
+	serial := func(info *ObjectInfo) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return fn(info)
+	}
+
+	var errOnce sync.Once
+	var firstErr error
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	listers := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	var walk func(prefix string, level int)
+	walk = func(prefix string, level int) {
+		defer wg.Done()
+		select {
+		case listers <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		var prefixes []string
+		var err error
+		if level == depth {
+			err = src.List(ctx, prefix, serial)
+		} else {
+			prefixes, err = src.ListLevel(ctx, prefix, serial)
+		}
+		<-listers
+		if errors.Is(err, errInterrupted) {
+			fail(err)
+			return
+		}
+		if err != nil {
+			fail(fmt.Errorf("listing %q: %w", prefix, err))
+			return
+		}
+		for _, p := range prefixes {
+			wg.Add(1)
+			go walk(p, level+1)
+		}
+	}
+
+	wg.Add(1)
+	go walk("", 0)
+	wg.Wait()
+
+	return firstErr
+}
+
+// readInventory calls fn for every object in an inventory file: CSV with a
+// header row (as written by GCS Storage Insights or by hand) or JSON lines.
+// Columns are matched by name, ignoring case, "_" and "-":
+//
+//	name or key          object key, required
+//	size                 bytes
+//	generation           GCS generation
+//	timecreated/created  creation time, RFC 3339
+//	updated/lastmodified update time, RFC 3339
+//	crc32c, md5hash/md5  base64 as in the GCS API, or hex
+//
+// The inventory is trusted as it is. Objects deleted since it was made
+// fail to copy, and objects added since are not seen, which is why
+// sync_delete refuses inventories.
+func readInventory(ctx context.Context, path string, fn func(*ObjectInfo) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open inventory: %w", err)
+	}
+	defer f.Close()
+
+	next := csvInventory(f)
+	if strings.HasSuffix(path, ".jsonl") || strings.HasSuffix(path, ".json") {
+		next = jsonInventory(f)
+	}
+	for line := 1; ; line++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		row, err := next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("inventory %s: %w", path, err)
+		}
+		if row == nil {
+			continue
+		}
+		info, err := inventoryObject(row)
+		if err != nil {
+			return fmt.Errorf("inventory %s, line %d: %w", path, line, err)
+		}
+		if strings.HasSuffix(info.Key, "/") {
+			continue
+		}
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+}
+
+// inventoryColumn normalises a column name for matching
+func inventoryColumn(name string) string {
+	return strings.ToLower(strings.NewReplacer("_", "", "-", "", " ", "").Replace(name))
+}
+
+func csvInventory(r io.Reader) func() (map[string]string, error) {
+	cr := csv.NewReader(bufio.NewReader(r))
+	cr.FieldsPerRecord = -1
+	var header []string
+	return func() (map[string]string, error) {
+		record, err := cr.Read()
+		if err != nil {
+			return nil, err
+		}
+		if header == nil {
+			for _, name := range record {
+				header = append(header, inventoryColumn(strings.TrimPrefix(name, "\ufeff")))
+			}
+			return nil, nil
+		}
+		row := make(map[string]string, len(header))
+		for i, value := range record {
+			if i < len(header) {
+				row[header[i]] = value
+			}
+		}
+		return row, nil
+	}
+}
+
+func jsonInventory(r io.Reader) func() (map[string]string, error) {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	dec.UseNumber()
+	return func() (map[string]string, error) {
+		var fields map[string]any
+		if err := dec.Decode(&fields); err != nil {
+			return nil, err
+		}
+		row := make(map[string]string, len(fields))
+		for name, value := range fields {
+			if value != nil {
+				row[inventoryColumn(name)] = fmt.Sprint(value)
+			}
+		}
+		return row, nil
+	}
+}
+
+// inventoryObject turns an inventory row into the ObjectInfo a listing
+// would give
+func inventoryObject(row map[string]string) (*ObjectInfo, error) {
+	field := func(names ...string) string {
+		for _, name := range names {
+			if value := row[name]; value != "" {
+				return value
+			}
+		}
+		return ""
+	}
+
+	info := &ObjectInfo{Key: field("name", "key", "object")}
+	if info.Key == "" {
+		return nil, errors.New("no name or key")
+	}
+	var err error
+	if v := field("size"); v != "" {
+		if info.Size, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, fmt.Errorf("size: %w", err)
+		}
+	}
+	if v := field("generation"); v != "" {
+		if info.Generation, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, fmt.Errorf("generation: %w", err)
+		}
+	}
+	if v := field("timecreated", "created"); v != "" {
+		if info.Created, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, fmt.Errorf("created: %w", err)
+		}
+	}
+	if v := field("updated", "lastmodified", "lastmodifieddate"); v != "" {
+		if info.Updated, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, fmt.Errorf("updated: %w", err)
+		}
+	}
+	if v := field("crc32c"); v != "" {
+		b, err := inventoryDigest(v, 4)
+		if err != nil {
+			return nil, fmt.Errorf("crc32c: %w", err)
+		}
+		info.CRC32C = binary.BigEndian.Uint32(b)
+		info.HasCRC32C = true
+	}
+	if v := field("md5hash", "md5"); v != "" {
+		if info.MD5, err = inventoryDigest(v, 16); err != nil {
+			return nil, fmt.Errorf("md5: %w", err)
+		}
+	}
+	return info, nil
+}
+
+// inventoryDigest decodes a digest of size bytes given in base64 or hex
+func inventoryDigest(value string, size int) ([]byte, error) {
+	if b, err := base64.StdEncoding.DecodeString(value); err == nil && len(b) == size {
+		return b, nil
+	}
+	if b, err := hex.DecodeString(value); err == nil && len(b) == size {
+		return b, nil
+	}
+	return nil, fmt.Errorf("%q is not a %d byte digest in base64 or hex", value, size)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log formats
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// TimestampLogger writes leveled log records to stdout and the log file,
+// as timestamped text lines or as JSON. Per-object lines go through Detail
+// so quiet mode can drop them and keep the rest.
+type TimestampLogger struct {
+	slog  *slog.Logger
+	file  *os.File
+	quiet bool
+	// blank lines only space out text logs
+	blank bool
+}
+
+func NewTimestampLogger(config *Config) (*TimestampLogger, error) {
+	f, err := os.OpenFile(config.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	// Write to both file and stdout
+	w := io.MultiWriter(console, f)
+	var handler slog.Handler
+	if config.LogFormat == logFormatJSON {
+		handler = slog.NewJSONHandler(w, &slog.HandlerOptions{Level: config.LogLevelValue})
+	} else {
+		handler = &lineHandler{mu: &sync.Mutex{}, w: w, level: config.LogLevelValue}
+	}
+
+	return &TimestampLogger{
+		slog:  slog.New(handler),
+		file:  f,
+		quiet: config.Quiet,
+		blank: config.LogFormat == logFormatText,
+	}, nil
+}
+
+// parseLogLevel reads debug, info, warn or error
+func parseLogLevel(value string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(value)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q (use debug, info, warn or error)", value)
+	}
+	return level, nil
+}
+
+// Log writes a free-form info line, for banners and summaries
+func (tl *TimestampLogger) Log(format string, v ...interface{}) {
+	message := fmt.Sprintf(format, v...)
+	if message == "" && !tl.blank {
+		return
+	}
+	tl.slog.Info(message)
+}
+
+// Detail logs a per-object info event, left out in quiet mode
+func (tl *TimestampLogger) Detail(msg string, args ...any) {
+	if !tl.quiet {
+		tl.slog.Info(msg, args...)
+	}
+}
+
+func (tl *TimestampLogger) Debug(msg string, args ...any) {
+	tl.slog.Debug(msg, args...)
+}
+
+func (tl *TimestampLogger) Info(msg string, args ...any) {
+	tl.slog.Info(msg, args...)
+}
+
+func (tl *TimestampLogger) Warn(msg string, args ...any) {
+	tl.slog.Warn(msg, args...)
+}
+
+func (tl *TimestampLogger) Error(msg string, args ...any) {
+	tl.slog.Error(msg, args...)
+}
+
+// With returns a logger that adds the given fields to every event
+func (tl *TimestampLogger) With(args ...any) *TimestampLogger {
+	return &TimestampLogger{slog: tl.slog.With(args...), file: tl.file, quiet: tl.quiet, blank: tl.blank}
+}
+
+// Close flushes the log file to disk and closes it
+func (tl *TimestampLogger) Close() error {
+	if err := tl.file.Sync(); err != nil {
+		tl.file.Close()
+		return fmt.Errorf("failed to flush log file: %w", err)
+	}
+	return tl.file.Close()
+}
+
+// lineHandler writes "2006-01-02 15:04:05 - message key=value" lines, the
+// level only shown when it is not info
+type lineHandler struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	level  slog.Leveler
+	attrs  []byte
+	prefix string
+}
+
+func (h *lineHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *lineHandler) Handle(_ context.Context, r slog.Record) error {
+	var buf bytes.Buffer
+	buf.WriteString(r.Time.Format("2006-01-02 15:04:05"))
+	buf.WriteString(" - ")
+	if r.Level != slog.LevelInfo {
+		buf.WriteString(r.Level.String())
+		buf.WriteByte(' ')
+	}
+	buf.WriteString(r.Message)
+	buf.Write(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		appendAttr(&buf, h.prefix, a)
+		return true
+	})
+	buf.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.w.Write(buf.Bytes())
+	return err
+}
+
+func (h *lineHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var buf bytes.Buffer
+	buf.Write(h.attrs)
+	for _, a := range attrs {
+		appendAttr(&buf, h.prefix, a)
+	}
+	out := *h
+	out.attrs = buf.Bytes()
+	return &out
+}
+
+func (h *lineHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	out := *h
+	out.prefix = h.prefix + name + "."
+	return &out
+}
+
+// appendAttr writes " key=value", quoting values that need it
+func appendAttr(buf *bytes.Buffer, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		for _, ga := range a.Value.Group() {
+			appendAttr(buf, prefix+a.Key+".", ga)
+		}
+		return
+	}
+	var value string
+	switch a.Value.Kind() {
+	case slog.KindDuration:
+		value = a.Value.Duration().Round(time.Millisecond).String()
+	case slog.KindTime:
+		value = a.Value.Time().Format(time.RFC3339)
+	default:
+		value = a.Value.String()
+	}
+	if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+		value = strconv.Quote(value)
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(prefix + a.Key)
+	buf.WriteByte('=')
+	buf.WriteString(value)
+}
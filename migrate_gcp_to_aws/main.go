@@ -2,116 +2,60 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"log"
-	"os"
 	"path/filepath"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
-
-	"cloud.google.com/go/storage"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
-	"google.golang.org/api/iterator"
 )
 
-// Configuration struct
-type Config struct {
-	GCSBucket          string    `json:"gcs_bucket"`
-	S3Bucket           string    `json:"s3_bucket"`
-	AWSCredentialsFile string    `json:"aws_credentials_file"`
-	AWSRegion          string    `json:"aws_region"`
-	LogFile            string    `json:"log_file"`
-	CutoffDate         time.Time `json:"-"`
-	CutoffDateStr      string    `json:"cutoff_date"`
-	MaxWorkers         int       `json:"max_workers"`
-	VideoExtensions    []string  `json:"video_extensions"`
-}
-
-// LoadConfig loads configuration from JSON file or returns defaults
-func LoadConfig(configPath string) (*Config, error) {
-	config := &Config{
-		GCSBucket:          "",
-		S3Bucket:           "",
-		AWSCredentialsFile: "/home/sadiq/projects/scripts/migrate_gcp_to_aws/.aws/credentials",
-		AWSRegion:          "",
-		LogFile:            "/home/sadiq/projects/scripts/migrate_gcp_to_aws/logs/migrate_gcp_to_s3.log",
-		CutoffDateStr:      "2025-09-07",
-		MaxWorkers:         20,
-		VideoExtensions:    []string{".mp4", ".avi", ".mov", ".mkv", ".webm", ".m4v"},
-	}
-
-	// Try to load from config file if it exists
-	if configPath != "" {
-		data, err := os.ReadFile(configPath)
-		if err == nil {
-			if err := json.Unmarshal(data, config); err != nil {
-				return nil, fmt.Errorf("failed to parse config file: %w", err)
-			}
-		}
-	}
-
-	// Parse cutoff date
-	cutoffDate, err := time.Parse("2006-01-02", config.CutoffDateStr)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse cutoff date: %w", err)
-	}
-	config.CutoffDate = cutoffDate
-
-	return config, nil
-}
-
 // Stats for tracking progress
 type Stats struct {
 	totalFiles      atomic.Int64
 	copiedFiles     atomic.Int64
 	skippedExisting atomic.Int64
 	errorFiles      atomic.Int64
+	checksumErrors  atomic.Int64
+	retries         atomic.Int64
+	interrupted     atomic.Int64
+	overwritten     atomic.Int64
+
+	// For the status server and progress display. Queued totals grow
+	// until scanDone, transferred counts every byte read, retries too.
+	queued           atomic.Int64
+	queuedBytes      atomic.Int64
+	scanDone         atomic.Bool
+	copiedBytes      atomic.Int64
+	skippedBytes     atomic.Int64
+	failedBytes      atomic.Int64
+	transferredBytes atomic.Int64
+	throughput       throughputMeter
+	finished         atomic.Bool
+	started          time.Time
+	queueDepth       func() int
+	copyDuration     *histogram
+	workersMu        sync.Mutex
+	workers          map[int]*WorkerStatus
+
+	// Per-object results, nil without a report directory
+	results *ResultReport
+}
+
+func newStats() *Stats {
+	return &Stats{started: time.Now(), copyDuration: newHistogram(copyDurationBuckets)}
 }
 
-// FileJob represents a file to be migrated
+// FileJob represents a file to be migrated. GCSPath is the source key and
+// RelativePath the destination key, whichever stores are in use.
 type FileJob struct {
 	GCSPath      string
 	RelativePath string
 	CreatedTime  time.Time
-}
-
-// Logger with timestamp
-type TimestampLogger struct {
-	logger *log.Logger
-	mu     sync.Mutex
-}
-
-func NewTimestampLogger(logFile string) (*TimestampLogger, error) {
-	f, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open log file: %w", err)
-	}
-
-	// Write to both file and stdout
-	multiWriter := io.MultiWriter(os.Stdout, f)
-	logger := log.New(multiWriter, "", 0)
-
-	return &TimestampLogger{logger: logger}, nil
-}
-
-func (tl *TimestampLogger) Log(format string, v ...interface{}) {
-	tl.mu.Lock()
-	defer tl.mu.Unlock()
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
-	message := fmt.Sprintf(format, v...)
-	tl.logger.Printf("%s - %s", timestamp, message)
-}
-
-func (tl *TimestampLogger) Close() {
-	// The file will be closed when the program exits
+	Generation   int64
+	Size         int64
+	S3Options    *DestinationOptions
 }
 
 // Check if file extension is a video
@@ -125,296 +69,453 @@ func isVideoFile(filename string, extensions []string) bool {
 	return false
 }
 
-// Extract date from folder path (e.g., "port1/2025-09-07/file.mp4" -> "2025-09-07")
-// Path format: port1/2025-07-15/recording_...
-func extractDateFromPath(path string) (time.Time, error) {
-	// Get the second part of the path (date folder)
-	parts := strings.Split(path, "/")
-	if len(parts) < 2 {
-		return time.Time{}, fmt.Errorf("path does not have enough segments: %s", path)
+// Copy a single object from the source to the destination and verify it.
+// This is one attempt, the worker wraps it in the retry policy.
+func copyObject(
+	ctx context.Context,
+	job *FileJob,
+	config *Config,
+	src ObjectStore,
+	dst ObjectStore,
+	journal *Journal,
+	logger *TimestampLogger,
+	counted func(n int),
+) (*PutResult, error) {
+	// Open the source, pinned to the generation that was listed
+	reader, info, err := src.Open(ctx, job.GCSPath, job.Generation)
+	if err != nil {
+		return nil, fmt.Errorf("opening source object: %w", err)
+	}
+	defer reader.Close()
+	job.Generation = info.Generation
+	job.Size = info.Size
+
+	logger.Detail("⬆ Copying", "destination", dst.URI(), "size", info.Size)
+	if err := journal.Record(*job, JournalCopying, ""); err != nil {
+		logger.Warn("⚠ Journal write failed", "error", err)
 	}
 
-	// Try to parse the second part as a date (YYYY-MM-DD format)
-	dateStr := parts[1]
-	date, err := time.Parse("2006-01-02", dateStr)
+	body := &progressReader{r: limits.Reader(ctx, reader), counted: counted}
+	result, err := dst.Put(ctx, job.RelativePath, body, PutOptions{
+		Source:      info,
+		Headers:     objectHeaders(info),
+		Metadata:    objectMetadata(info, src.URI(), config),
+		Destination: job.S3Options,
+	})
+	if err == nil {
+		if err := journal.Record(*job, JournalCopied, ""); err != nil {
+			logger.Warn("⚠ Journal write failed", "error", err)
+		}
+		// Verify the streamed bytes against the source digests
+		err = verifyChecksums(info, result.Digest)
+	}
+	if errors.Is(err, errChecksumMismatch) {
+		// Remove the bad copy so a later run does not treat it as migrated
+		if derr := dst.Delete(ctx, job.RelativePath, 0); derr != nil && !errors.Is(derr, errObjectNotFound) {
+			logger.Error("✗ Could not remove unverified copy", "error", derr)
+		}
+	}
 	if err != nil {
-		return time.Time{}, fmt.Errorf("folder name is not a valid date: %s", dateStr)
+		return nil, fmt.Errorf("copying to %s: %w", dst.URI(), err)
+	}
+	if result.Upload.PartSize > 0 {
+		logger.Detail("Uploaded", "upload", result.Upload.String())
 	}
 
-	return date, nil
+	return result, nil
 }
 
-// Check if file exists in S3
-func fileExistsInS3(ctx context.Context, s3Client *s3.S3, bucket, key string) bool {
-	_, err := s3Client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-	})
-	return err == nil
+// migrationRun is one migration feeding the worker pool: where its
+// objects come from and go, and where their outcomes are counted
+type migrationRun struct {
+	config  *Config
+	src     ObjectStore
+	dst     ObjectStore
+	stats   *Stats
+	journal *Journal
+	logger  *TimestampLogger
+	// Objects handed to the pool and not done yet
+	pending sync.WaitGroup
 }
 
-// Worker function to process files
-func worker(
-	ctx context.Context,
-	id int,
-	jobs <-chan FileJob,
-	config *Config,
-	gcsClient *storage.Client,
-	s3Client *s3.S3,
-	uploader *s3manager.Uploader,
-	stats *Stats,
-	logger *TimestampLogger,
-	wg *sync.WaitGroup,
-) {
-	defer wg.Done()
-
-	for job := range jobs {
-		stats.totalFiles.Add(1)
-		current := stats.totalFiles.Load()
-
-		logger.Log("Worker %d - [%d] Processing: %s (dated %s)",
-			id, current, job.RelativePath, job.CreatedTime.Format("2006-01-02"))
-
-		// Check if file already exists in S3
-		if fileExistsInS3(ctx, s3Client, config.S3Bucket, job.RelativePath) {
-			logger.Log("  Worker %d - ⊘ File already exists in S3, skipping", id)
-			stats.skippedExisting.Add(1)
-			continue
-		}
+// workItem is one object of one run
+type workItem struct {
+	job FileJob
+	run *migrationRun
+}
 
-		// Open GCS file
-		gcsObj := gcsClient.Bucket(config.GCSBucket).Object(job.GCSPath)
-		reader, err := gcsObj.NewReader(ctx)
-		if err != nil {
-			logger.Log("  Worker %d - ✗ Error opening GCS file: %v", id, err)
-			stats.errorFiles.Add(1)
-			continue
-		}
+// workerPool copies the objects of every run fed to it, so the jobs of a
+// multi-job run share one set of workers
+type workerPool struct {
+	items chan workItem
+	size  int
+	wg    sync.WaitGroup
+}
 
-		// Get file size for logging
-		attrs, _ := gcsObj.Attrs(ctx)
-		var sizeStr string
-		if attrs != nil {
-			sizeMB := float64(attrs.Size) / (1024 * 1024)
-			sizeStr = fmt.Sprintf(" (%.2f MB)", sizeMB)
-		}
+func startWorkerPool(ctx context.Context, size int) *workerPool {
+	p := &workerPool{items: make(chan workItem, size*2), size: size}
+	for i := 1; i <= size; i++ {
+		p.wg.Add(1)
+		go p.worker(ctx, i)
+	}
+	return p
+}
 
-		// Upload to S3
-		logger.Log("  Worker %d - ⬆ Copying to S3%s...", id, sizeStr)
-		startTime := time.Now()
-		_, err = uploader.UploadWithContext(ctx, &s3manager.UploadInput{
-			Bucket: aws.String(config.S3Bucket),
-			Key:    aws.String(job.RelativePath),
-			Body:   reader,
-		})
-		reader.Close()
-		duration := time.Since(startTime)
+// Close stops the workers once every run is done feeding them
+func (p *workerPool) Close() {
+	close(p.items)
+	p.wg.Wait()
+}
 
-		if err != nil {
-			logger.Log("  Worker %d - ✗ Error uploading to S3: %v", id, err)
-			stats.errorFiles.Add(1)
-			continue
+// Worker function to process files
+func (p *workerPool) worker(ctx context.Context, id int) {
+	defer p.wg.Done()
+	for item := range p.items {
+		// Jobs left in the queue stay queued in the journal for the next run
+		concurrency.Acquire()
+		if !shutdown.Requested() {
+			item.run.copy(ctx, id, item.job)
+			item.run.stats.setWorker(id, nil)
 		}
-
-		copied := stats.copiedFiles.Add(1)
-		logger.Log("  Worker %d - ✓ Successfully copied in %.1fs (total: %d files)",
-			id, duration.Seconds(), copied)
+		concurrency.Release()
+		item.run.pending.Done()
 	}
 }
 
-func main() {
-	// Load configuration (tries migrate_config.json first, falls back to defaults)
-	configPath := "migrate_config.json"
-	config, err := LoadConfig(configPath)
-	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+// copy copies one object of the run with retries and records the outcome
+func (r *migrationRun) copy(ctx context.Context, id int, job FileJob) {
+	config, src, dst, stats, journal := r.config, r.src, r.dst, r.stats, r.journal
+	policy := config.RetryPolicy()
+	wl := r.logger.With("worker", id)
+
+	progress := stats.setWorker(id, &job)
+	stats.totalFiles.Add(1)
+	current := stats.totalFiles.Load()
+
+	jl := wl.With("key", job.GCSPath)
+	jl.Detail("Processing", "n", current, "destination", job.RelativePath,
+		"date", job.CreatedTime.Format("2006-01-02"), "size", job.Size)
+
+	// Check if file already exists at the destination, and in sync
+	// mode whether it still matches the source
+	needed, reason := needsCopy(ctx, config, src, dst, job.GCSPath, job.RelativePath)
+	if !needed {
+		jl.Detail("⊘ File already exists at destination, skipping")
+		stats.skippedExisting.Add(1)
+		stats.skippedBytes.Add(job.Size)
+		stats.recordResult(newObjectResult(&job, resultSkipped), jl)
+		return
 	}
-
-	// Create log directory
-	logDir := filepath.Dir(config.LogFile)
-	if err := os.MkdirAll(logDir, 0755); err != nil {
-		log.Fatalf("Failed to create log directory: %v", err)
+	if reason != "" {
+		jl.Detail("↻ Destination differs, overwriting", "reason", reason)
+		stats.overwritten.Add(1)
 	}
 
-	// Initialize logger
-	logger, err := NewTimestampLogger(config.LogFile)
-	if err != nil {
-		log.Fatalf("Failed to initialize logger: %v", err)
+	var put *PutResult
+	startTime := time.Now()
+	attempts, err := policy.Do(ctx, func(ctx context.Context) error {
+		var err error
+		// Each attempt reads the object from the start
+		progress.Store(0)
+		put, err = copyObject(ctx, &job, config, src, dst, journal, jl, stats.transferCounter(progress))
+		return err
+	}, func(attempt int, err error, class errorClass, delay time.Duration) {
+		stats.retries.Add(1)
+		concurrency.ObserveError(class)
+		jl.Warn("↻ Attempt failed, retrying", "attempt", attempt, "max_attempts", policy.MaxAttempts,
+			"class", class, "error", err, "delay", delay)
+	})
+	duration := time.Since(startTime)
+	result := newObjectResult(&job, resultCopied)
+	result.Duration = duration.Seconds()
+	result.Attempts = attempts
+
+	if err != nil && shutdown.Requested() && ctx.Err() != nil {
+		jl.Warn("⊘ Interrupted, it will be copied again on the next run")
+		stats.interrupted.Add(1)
+		result.Outcome = resultInterrupted
+		stats.recordResult(result, jl)
+		return
 	}
-
-	ctx := context.Background()
-
-	// Initialize GCS client
-	logger.Log("Initializing GCS client...")
-	gcsClient, err := storage.NewClient(ctx)
 	if err != nil {
-		logger.Log("Failed to create GCS client: %v", err)
-		logger.Log("Please run: gcloud auth application-default login")
-		os.Exit(1)
+		class := classifyError(err)
+		concurrency.ObserveError(class)
+		jl.Error("✗ Failed", "attempts", attempts, "class", class, "error", err, "duration", duration)
+		result.Outcome = resultFailed
+		if class == classChecksum {
+			stats.checksumErrors.Add(1)
+			result.Outcome = resultMismatch
+		} else {
+			stats.errorFiles.Add(1)
+		}
+		result.ErrorClass = string(class)
+		result.Error = err.Error()
+		stats.recordResult(result, jl)
+		stats.failedBytes.Add(job.Size)
+		if err := journal.Record(job, JournalFailed, err.Error()); err != nil {
+			jl.Warn("⚠ Journal write failed", "error", err)
+		}
+		return
 	}
-	defer gcsClient.Close()
 
-	// Initialize AWS session
-	logger.Log("Initializing AWS session...")
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String(config.AWSRegion),
-		Credentials: credentials.NewSharedCredentials(config.AWSCredentialsFile, "default"),
-	})
-	if err != nil {
-		logger.Log("Failed to create AWS session: %v", err)
-		os.Exit(1)
+	if err := journal.Record(job, JournalVerified, ""); err != nil {
+		jl.Warn("⚠ Journal write failed", "error", err)
 	}
+	concurrency.ObserveSuccess(job.Size, duration)
+	copied := stats.copiedFiles.Add(1)
+	stats.copiedBytes.Add(job.Size)
+	stats.copyDuration.Observe(duration.Seconds())
+	result.CRC32C = fmt.Sprintf("%08x", put.Digest.CRC32C)
+	if put.Upload.PartSize > 0 {
+		result.Upload = put.Upload.Method()
+		result.PartSize = put.Upload.PartSize
+		result.Parts = put.Upload.Parts
+	}
+	stats.recordResult(result, jl)
+	jl.Detail("✓ Successfully copied and verified", "size", job.Size, "duration", duration,
+		"crc32c", result.CRC32C, "attempts", attempts, "total", copied)
+}
 
-	s3Client := s3.New(sess)
-
-	// Configure uploader for better performance
-	uploader := s3manager.NewUploader(sess, func(u *s3manager.Uploader) {
-		u.PartSize = 10 * 1024 * 1024 // 10MB parts (default is 5MB)
-		u.Concurrency = 5             // Upload 5 parts concurrently per file
-		u.LeavePartsOnError = false   // Clean up failed uploads
-	})
+// runMigration copies the approved manifest entries, or everything eligible
+// in the bucket when manifest is nil, and prints the summary. It returns an
+// error when any file could not be copied. The copies are done by pool,
+// or by a pool of MaxWorkers of its own when pool is nil. Sync deletions
+// draw on budget.
+func runMigration(
+	ctx context.Context,
+	config *Config,
+	src ObjectStore,
+	dst ObjectStore,
+	journal *Journal,
+	logger *TimestampLogger,
+	manifest []ManifestEntry,
+	budget *deletionBudget,
+	pool *workerPool,
+) error {
+	if pool == nil {
+		pool = startWorkerPool(ctx, config.MaxWorkers)
+		defer pool.Close()
+	}
 
-	logger.Log("Starting migration from GCS to S3...")
-	logger.Log("Cutoff date: %s (only copying files from this date onwards)", config.CutoffDate.Format("2006-01-02"))
-	logger.Log("Source: gs://%s", config.GCSBucket)
-	logger.Log("Destination: s3://%s", config.S3Bucket)
-	logger.Log("Max concurrent workers: %d", config.MaxWorkers)
-
-	// Create job channel and stats
-	jobs := make(chan FileJob, config.MaxWorkers*2)
-	stats := &Stats{}
-
-	// Start workers
-	var wg sync.WaitGroup
-	for i := 1; i <= config.MaxWorkers; i++ {
-		wg.Add(1)
-		go worker(ctx, i, jobs, config, gcsClient, s3Client, uploader, stats, logger, &wg)
+	logger.Log("Starting migration...")
+	logger.Log("Date window: %s (only copying files dated inside it)", config.DateWindow())
+	logger.Log("Source: %s", src.URI())
+	logger.Log("Destination: %s", dst.URI())
+	logger.Log("Max concurrent workers: %d", pool.size)
+	logger.Log("Retries: up to %d attempts, backoff %s-%s", config.RetryMaxAttempts, config.RetryBaseDelay, config.RetryMaxDelay)
+	logger.Log("Limits: %s (SIGHUP reloads them from the config file)", limits.Describe())
+	logger.Log("Concurrency: %s", concurrency.Describe())
+	logger.Log("S3 options: %s", config.S3Options.String())
+	if config.Sync {
+		mode := "overwriting objects that differ"
+		if config.SyncDelete {
+			mode += ", deleting objects missing from the source"
+			if config.SyncDeleteScope != "" {
+				mode += " under " + config.SyncDeleteScope
+			}
+		}
+		logger.Log("Sync mode: comparing %s, %s", strings.Join(config.SyncCompare, ", "), mode)
+	}
+	if config.Move {
+		logger.Log("Move mode: sources are deleted %s after their copy is verified (at most %d per run)",
+			config.MoveDelay, config.MaxDeletes)
+	}
+	counts := journal.Counts()
+	logger.Log("Journal: %s (%d verified, %d failed, %d unfinished from earlier runs)",
+		config.JournalFile, counts[JournalVerified], counts[JournalFailed],
+		counts[JournalQueued]+counts[JournalCopying]+counts[JournalCopied])
+
+	stats := newStats()
+	stats.queueDepth = func() int { return len(pool.items) }
+	activeStats.Store(config.Job, stats)
+	defer stats.finished.Store(true)
+
+	if config.ReportDir != "" {
+		report, err := OpenResultReport(config.ReportDir, config.Job, stats.started)
+		if err != nil {
+			return err
+		}
+		stats.results = report
+		logger.Log("Result reports: %s", strings.Join(report.Paths(), ", "))
 	}
 
-	// List all objects in GCS bucket and send to workers
-	bucket := gcsClient.Bucket(config.GCSBucket)
-	query := &storage.Query{Prefix: ""}
-	it := bucket.Objects(ctx, query)
+	run := &migrationRun{config: config, src: src, dst: dst, stats: stats, journal: journal, logger: logger}
+
+	// Report progress from the start, the workers copy while the scan runs
+	stopProgress := monitorProgress(config, stats, logger)
+	startProcessingTime := time.Now()
 
 	filesQueued := 0
 	skippedByDate := 0
+	skippedByJournal := 0
+	keyErrors := 0
 	totalProcessed := 0
-
-	logger.Log("Scanning GCS bucket and queuing eligible files...")
-	logger.Log("(Files before %s will be skipped)", config.CutoffDate.Format("2006-01-02"))
-	logger.Log("")
-
-	for {
-		attrs, err := it.Next()
-		if err == iterator.Done {
-			break
+	claims := keyClaims{}
+	// Destination objects to delete in sync mode, and the keys the source
+	// still maps to
+	var extras []ManifestEntry
+	keep := map[string]bool{}
+	listed := false
+
+	// Queue an eligible file unless a previous run already verified it
+	queue := func(entry ManifestEntry) {
+		if other, ok := claims.claim(entry.Destination, entry.Source); !ok {
+			logger.Error("✗ Skipped, destination collides", "key", entry.Source, "destination", entry.Destination, "other", other)
+			keyErrors++
+			return
 		}
-		if err != nil {
-			logger.Log("Error listing GCS objects: %v", err)
-			break
+		// Sync mode checks the destination itself, whatever the journal says
+		if prev, ok := journal.Lookup(entry.Source, entry.Generation); ok && !config.Sync && (prev.State == JournalVerified || prev.State == JournalDeleted) {
+			logger.Detail("⊘ Skipped, already verified (journal)", "key", entry.Source, "verified", prev.Time)
+			skippedByJournal++
+			return
 		}
 
-		// Skip directories
-		if strings.HasSuffix(attrs.Name, "/") {
-			continue
-		}
+		logger.Detail("✓ Eligible, queuing for copy", "key", entry.Source, "date", entry.Date, "destination", entry.Destination)
 
-		// Check if it's a video file
-		if !isVideoFile(attrs.Name, config.VideoExtensions) {
-			continue
+		job := entry.Job()
+		if err := journal.Record(job, JournalQueued, ""); err != nil {
+			logger.Warn("⚠ Journal write failed", "error", err)
+		}
+		run.pending.Add(1)
+		select {
+		case pool.items <- workItem{job: job, run: run}:
+			filesQueued++
+			stats.queued.Add(1)
+			stats.queuedBytes.Add(job.Size)
+		case <-shutdown.Stopping():
+			run.pending.Done()
 		}
+	}
 
-		totalProcessed++
-		logger.Log("Scanning [%d]: %s", totalProcessed, attrs.Name)
+	if manifest != nil {
+		// Only copy what reviewers approved in the plan
+		logger.Log("Queuing approved files from manifest (%d entries)...", len(manifest))
+		logger.Log("")
 
-		// Check folder date (primary filter)
-		folderDate, err := extractDateFromPath(attrs.Name)
-		if err != nil {
-			logger.Log("  ✗ Skipped: Could not extract valid date from path (%v)", err)
-			skippedByDate++
-			continue
+		for _, entry := range manifest {
+			if shutdown.Requested() {
+				break
+			}
+			if entry.Decision == DecisionDeleteExtra && config.SyncDelete {
+				extras = append(extras, entry)
+				continue
+			}
+			if entry.Decision != DecisionCopy {
+				continue
+			}
+			totalProcessed++
+			logger.Debug("Scanning", "n", totalProcessed, "key", entry.Source)
+			queue(entry)
 		}
+	} else {
+		// List all objects in the source and send to workers
+		logger.Log("Scanning %s and queuing eligible files...", src.URI())
+		logger.Log("(Files outside %s will be skipped)", config.DateWindow())
+		logger.Log("")
+
+		err := listSource(ctx, config, src, logger, func(info *ObjectInfo) error {
+			if shutdown.Requested() {
+				return errInterrupted
+			}
+			// Check filters and date, the same way plan mode does
+			entry := planObject(info, config)
+			keep[entry.Destination] = true
+			if entry.Decision == DecisionSkipExtension || entry.Decision == DecisionSkipFilter {
+				return nil
+			}
 
-		// Use folder date for filtering
-		if folderDate.Before(config.CutoffDate) {
-			logger.Log("  ✗ Skipped: File dated %s (before %s)",
-				folderDate.Format("2006-01-02"), config.CutoffDate.Format("2006-01-02"))
-			skippedByDate++
-			continue
-		}
+			totalProcessed++
+			logger.Debug("Scanning", "n", totalProcessed, "key", info.Key)
 
-		logger.Log("  ✓ Eligible: File dated %s - queuing for copy", folderDate.Format("2006-01-02"))
+			if entry.Decision == DecisionSkipDate {
+				logger.Detail("✗ Skipped", "key", info.Key, "reason", entry.Reason)
+				skippedByDate++
+				return nil
+			}
+			if entry.Decision == DecisionKeyError {
+				logger.Error("✗ Skipped", "key", info.Key, "reason", entry.Reason)
+				keyErrors++
+				return nil
+			}
 
-		// Create job
-		job := FileJob{
-			GCSPath:      attrs.Name,
-			RelativePath: attrs.Name,
-			CreatedTime:  folderDate,
+			queue(entry)
+			return nil
+		})
+		if err != nil && !errors.Is(err, errInterrupted) {
+			logger.Error("Error listing source", "store", src.URI(), "error", err)
 		}
-
-		jobs <- job
-		filesQueued++
+		// Only a complete listing says what is missing from the source
+		listed = err == nil
 	}
 
-	// Close jobs channel and wait for workers to finish
-	close(jobs)
+	// Everything is queued, wait for the workers to finish this run's share
+	stats.scanDone.Store(true)
 	logger.Log("")
 	logger.Log("=== Scanning Complete ===")
 	logger.Log("Total video files scanned: %d", totalProcessed)
-	logger.Log("Files skipped (before cutoff date): %d", skippedByDate)
+	logger.Log("Files skipped (outside date window): %d", skippedByDate)
+	logger.Log("Files skipped (verified in journal): %d", skippedByJournal)
+	logger.Log("Files skipped (no usable destination key): %d", keyErrors)
 	logger.Log("Files queued for copying: %d", filesQueued)
 	logger.Log("")
-	logger.Log("=== Starting File Copy (20 workers in parallel) ===")
+	logger.Log("=== Starting File Copy (%d workers in parallel) ===", pool.size)
 	logger.Log("")
 
-	// Start a progress monitor
-	done := make(chan bool)
-	startProcessingTime := time.Now()
-	go func() {
-		ticker := time.NewTicker(10 * time.Second)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ticker.C:
-				elapsed := time.Since(startProcessingTime)
-				processed := stats.totalFiles.Load()
-				rate := float64(processed) / elapsed.Seconds()
-				logger.Log("")
-				logger.Log("⏱ Progress Update (%.0fs elapsed, %.1f files/sec):", elapsed.Seconds(), rate)
-				logger.Log("   Processed: %d/%d files", processed, filesQueued)
-				logger.Log("   ✓ Copied: %d", stats.copiedFiles.Load())
-				logger.Log("   ⊘ Skipped (already exist): %d", stats.skippedExisting.Load())
-				logger.Log("   ✗ Errors: %d", stats.errorFiles.Load())
-				logger.Log("")
-			case <-done:
-				return
-			}
-		}
-	}()
-
-	wg.Wait()
-	done <- true
+	run.pending.Wait()
+	stopProgress()
 	totalDuration := time.Since(startProcessingTime)
 
+	if config.SyncDelete && listed && !shutdown.Requested() {
+		found, err := findExtras(ctx, dst, config.SyncDeleteScope, keep)
+		if err != nil {
+			logger.Error("✗ Not deleting anything from the destination", "store", dst.URI(), "error", err)
+		}
+		extras = found
+	}
+	extrasDeleted, extrasFailed := deleteExtras(ctx, config, dst, extras, budget, logger)
+
 	// Print statistics
 	logger.Log("")
 	logger.Log("========================================")
-	logger.Log("           MIGRATION COMPLETE           ")
+	if shutdown.Requested() {
+		logger.Log("          MIGRATION INTERRUPTED         ")
+	} else {
+		logger.Log("           MIGRATION COMPLETE           ")
+	}
 	logger.Log("========================================")
 	logger.Log("")
+	if shutdown.Requested() {
+		logger.Log("Status: interrupted, run again to pick up where this run stopped")
+		logger.Log("")
+	}
 	logger.Log("Scanning Phase:")
 	logger.Log("  Total video files scanned: %d", totalProcessed)
-	logger.Log("  Files skipped (before cutoff %s): %d", config.CutoffDate.Format("2006-01-02"), skippedByDate)
+	logger.Log("  Files skipped (outside %s): %d", config.DateWindow(), skippedByDate)
+	logger.Log("  Files skipped (verified in journal): %d", skippedByJournal)
+	logger.Log("  ✗ Files skipped (no usable destination key): %d", keyErrors)
 	logger.Log("  Files queued for copying: %d", filesQueued)
 	logger.Log("")
 	logger.Log("Processing Phase:")
 	logger.Log("  Total files processed: %d", stats.totalFiles.Load())
-	logger.Log("  ✓ Files copied to S3: %d", stats.copiedFiles.Load())
+	logger.Log("  ✓ Files copied and verified: %d", stats.copiedFiles.Load())
 	logger.Log("  ⊘ Files skipped (already exist): %d", stats.skippedExisting.Load())
+	if config.Sync {
+		logger.Log("  ↻ Files overwritten (destination differed): %d", stats.overwritten.Load())
+	}
+	if config.SyncDelete {
+		logger.Log("  ✓ Destination objects deleted (missing from source): %d", extrasDeleted)
+		logger.Log("  ✗ Destination objects that could not be deleted: %d", extrasFailed)
+	}
 	logger.Log("  ✗ Errors: %d", stats.errorFiles.Load())
+	logger.Log("  ✗ Checksum mismatches: %d", stats.checksumErrors.Load())
+	logger.Log("  ⊘ Interrupted: %d", stats.interrupted.Load())
+	logger.Log("  ↻ Retries: %d", stats.retries.Load())
 	logger.Log("")
 	logger.Log("Performance:")
 	logger.Log("  Total time: %.1f seconds (%.1f minutes)", totalDuration.Seconds(), totalDuration.Minutes())
+	logger.Log("  Data read from source: %s (%s average)", formatBytes(stats.transferredBytes.Load()),
+		formatRate(float64(stats.transferredBytes.Load())/totalDuration.Seconds()))
 	if stats.copiedFiles.Load() > 0 {
 		avgTime := totalDuration.Seconds() / float64(stats.copiedFiles.Load())
 		logger.Log("  Average time per file: %.1f seconds", avgTime)
@@ -422,4 +523,33 @@ func main() {
 	}
 	logger.Log("")
 	logger.Log("========================================")
+
+	if stats.results != nil {
+		status := "complete"
+		if shutdown.Requested() {
+			status = "interrupted"
+		} else if failed := stats.errorFiles.Load() + stats.checksumErrors.Load(); failed > 0 {
+			status = fmt.Sprintf("complete, %d failed", failed)
+		}
+		if err := stats.results.Close(ReportSummary{
+			Status:      status,
+			Source:      src.URI(),
+			Destination: dst.URI(),
+			Window:      config.DateWindow(),
+			Started:     stats.started,
+			Duration:    time.Since(stats.started).Round(time.Second),
+		}); err != nil {
+			logger.Warn("⚠ Result report write failed", "error", err)
+		} else {
+			logger.Log("Result reports written to %s", config.ReportDir)
+		}
+	}
+
+	if shutdown.Requested() {
+		return errInterrupted
+	}
+	if failed := stats.errorFiles.Load() + stats.checksumErrors.Load() + int64(keyErrors+extrasFailed); failed > 0 {
+		return fmt.Errorf("%d files failed to migrate", failed)
+	}
+	return nil
 }
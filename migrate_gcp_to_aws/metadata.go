@@ -0,0 +1,92 @@
+package main
+
+import (
+	"mime"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ObjectHeaders are the content headers carried across with an object
+type ObjectHeaders struct {
+	ContentType        string
+	ContentDisposition string
+	ContentEncoding    string
+	ContentLanguage    string
+	CacheControl       string
+}
+
+// Provenance metadata keys, added to every copy when enabled. They go
+// through the metadata map like any user metadata, so they can be renamed
+// or dropped there.
+const (
+	metaSourceURI        = "source-uri"
+	metaSourceGeneration = "source-generation"
+	metaSourceCreated    = "source-created"
+	metaSourceUpdated    = "source-updated"
+)
+
+// objectHeaders returns the headers to write for a copy of info. Objects
+// stored without a useful Content-Type get one from their extension, so
+// players do not fall back to a download.
+func objectHeaders(info *ObjectInfo) ObjectHeaders {
+	headers := info.ObjectHeaders
+	if headers.ContentType == "" || headers.ContentType == "application/octet-stream" {
+		if guessed := mime.TypeByExtension(strings.ToLower(path.Ext(info.Key))); guessed != "" {
+			headers.ContentType = guessed
+		}
+	}
+	return headers
+}
+
+// objectMetadata builds the user metadata for a copy of info read from
+// srcURI: the source's own metadata plus provenance, renamed or dropped
+// according to config.MetadataMap
+func objectMetadata(info *ObjectInfo, srcURI string, config *Config) map[string]string {
+	meta := make(map[string]string)
+	if config.CopyMetadata {
+		for key, value := range info.Metadata {
+			meta[strings.ToLower(key)] = value
+		}
+	}
+
+	if config.ProvenanceMetadata {
+		meta[metaSourceURI] = strings.TrimSuffix(srcURI, "/") + "/" + info.Key
+		if info.Generation != 0 {
+			meta[metaSourceGeneration] = strconv.FormatInt(info.Generation, 10)
+		}
+		if !info.Created.IsZero() {
+			meta[metaSourceCreated] = info.Created.UTC().Format(time.RFC3339Nano)
+		}
+		if !info.Updated.IsZero() {
+			meta[metaSourceUpdated] = info.Updated.UTC().Format(time.RFC3339Nano)
+		}
+	}
+
+	mapped := make(map[string]string, len(meta))
+	for key, value := range meta {
+		if to, ok := config.MetadataMap[key]; ok {
+			// An empty target drops the key
+			if to == "" {
+				continue
+			}
+			key = strings.ToLower(to)
+		}
+		mapped[key] = metadataValue(value)
+	}
+	return mapped
+}
+
+// metadataValue makes a value safe for an HTTP header. S3 only stores
+// printable ASCII, so anything else is RFC 2047 encoded the way S3 itself
+// returns non-ASCII metadata.
+func metadataValue(value string) string {
+	for _, r := range value {
+		if r > unicode.MaxASCII || !unicode.IsPrint(r) {
+			return mime.QEncoding.Encode("utf-8", value)
+		}
+	}
+	return value
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// copyDurationBuckets are the upper bounds, in seconds, of the copy
+// duration histogram
+var copyDurationBuckets = []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1800}
+
+// histogram is a fixed-bucket Prometheus histogram
+type histogram struct {
+	mu      sync.Mutex
+	bounds  []float64
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, buckets: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *histogram) write(w io.Writer, name, job string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.bounds {
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, metricLabels(job, "le", fmt.Sprintf("%g", bound)), h.buckets[i])
+	}
+	fmt.Fprintf(w, "%s_bucket%s %d\n", name, metricLabels(job, "le", "+Inf"), h.count)
+	fmt.Fprintf(w, "%s_sum%s %g\n", name, metricLabels(job), h.sum)
+	fmt.Fprintf(w, "%s_count%s %d\n", name, metricLabels(job), h.count)
+}
+
+// WorkerStatus is what one worker is doing
+type WorkerStatus struct {
+	ID          int        `json:"id"`
+	Key         string     `json:"key,omitempty"`
+	Size        int64      `json:"size,omitempty"`
+	Transferred int64      `json:"transferred,omitempty"`
+	Since       *time.Time `json:"since,omitempty"`
+	Active      bool       `json:"active"`
+
+	progress *atomic.Int64
+}
+
+// setWorker records the job a worker started, or that it is idle when job
+// is nil. It returns the counter for the bytes read of the job.
+func (s *Stats) setWorker(id int, job *FileJob) *atomic.Int64 {
+	s.workersMu.Lock()
+	defer s.workersMu.Unlock()
+	if s.workers == nil {
+		s.workers = make(map[int]*WorkerStatus)
+	}
+	ws := &WorkerStatus{ID: id}
+	if job != nil {
+		now := time.Now()
+		ws.Key, ws.Size, ws.Since, ws.Active = job.RelativePath, job.Size, &now, true
+		ws.progress = &atomic.Int64{}
+	}
+	s.workers[id] = ws
+	return ws.progress
+}
+
+// Workers returns the status of every worker, ordered by ID
+func (s *Stats) Workers() []WorkerStatus {
+	s.workersMu.Lock()
+	defer s.workersMu.Unlock()
+	out := make([]WorkerStatus, 0, len(s.workers))
+	for _, ws := range s.workers {
+		status := *ws
+		if ws.progress != nil {
+			status.Transferred = ws.progress.Load()
+		}
+		out = append(out, status)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// StatusReport is the JSON served on /status
+type StatusReport struct {
+	State       string           `json:"state"`
+	Started     time.Time        `json:"started"`
+	Elapsed     float64          `json:"elapsed_seconds"`
+	Queued      int64            `json:"queued"`
+	QueueDepth  int              `json:"queue_depth"`
+	Processed   int64            `json:"processed"`
+	Copied      int64            `json:"copied"`
+	Skipped     int64            `json:"skipped_existing"`
+	Failed      int64            `json:"failed"`
+	Mismatched  int64            `json:"checksum_mismatches"`
+	Retries     int64            `json:"retries"`
+	Bytes       map[string]int64 `json:"bytes"`
+	Progress    Progress         `json:"progress"`
+	Limits      string           `json:"limits"`
+	Concurrency string           `json:"concurrency"`
+	Workers     []WorkerStatus   `json:"workers"`
+}
+
+// Report snapshots the stats
+func (s *Stats) Report() StatusReport {
+	state := "running"
+	if s.finished.Load() {
+		state = "finished"
+	}
+	depth := 0
+	if s.queueDepth != nil {
+		depth = s.queueDepth()
+	}
+	return StatusReport{
+		State:      state,
+		Started:    s.started,
+		Elapsed:    time.Since(s.started).Seconds(),
+		Queued:     s.queued.Load(),
+		QueueDepth: depth,
+		Processed:  s.totalFiles.Load(),
+		Copied:     s.copiedFiles.Load(),
+		Skipped:    s.skippedExisting.Load(),
+		Failed:     s.errorFiles.Load(),
+		Mismatched: s.checksumErrors.Load(),
+		Retries:    s.retries.Load(),
+		Bytes: map[string]int64{
+			"copied":  s.copiedBytes.Load(),
+			"skipped": s.skippedBytes.Load(),
+			"failed":  s.failedBytes.Load(),
+		},
+		Progress:    s.Progress(),
+		Limits:      limits.Describe(),
+		Concurrency: concurrency.Describe(),
+		Workers:     s.Workers(),
+	}
+}
+
+// writeMetrics writes the stats of every job in the Prometheus text
+// format, with a job label when the config has jobs
+func writeMetrics(w io.Writer, jobs []string, runs []*Stats) {
+	reports := make([]StatusReport, len(runs))
+	for i, s := range runs {
+		reports[i] = s.Report()
+	}
+	family := func(name, kind, help string, samples func(r StatusReport, s *Stats, sample func(value any, labels ...string))) {
+		fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+		for i := range runs {
+			samples(reports[i], runs[i], func(value any, labels ...string) {
+				fmt.Fprintf(w, "%s%s %v\n", name, metricLabels(jobs[i], labels...), value)
+			})
+		}
+	}
+
+	family("migrate_objects_total", "counter", "Objects handled, by result.", func(r StatusReport, _ *Stats, sample func(any, ...string)) {
+		for _, c := range []struct {
+			result string
+			n      int64
+		}{{"queued", r.Queued}, {"copied", r.Copied}, {"skipped", r.Skipped}, {"failed", r.Failed}, {"checksum_mismatch", r.Mismatched}} {
+			sample(c.n, "result", c.result)
+		}
+	})
+	family("migrate_bytes_total", "counter", "Bytes handled, by result.", func(r StatusReport, _ *Stats, sample func(any, ...string)) {
+		for _, result := range []string{"copied", "skipped", "failed"} {
+			sample(r.Bytes[result], "result", result)
+		}
+	})
+	family("migrate_transferred_bytes_total", "counter", "Bytes read from the source, retries included.", func(_ StatusReport, s *Stats, sample func(any, ...string)) {
+		sample(s.transferredBytes.Load())
+	})
+	family("migrate_throughput_bytes_per_second", "gauge", "Moving average of the copy throughput.", func(r StatusReport, _ *Stats, sample func(any, ...string)) {
+		sample(r.Progress.Throughput)
+	})
+	family("migrate_retries_total", "counter", "Copy attempts that were retried.", func(r StatusReport, _ *Stats, sample func(any, ...string)) {
+		sample(r.Retries)
+	})
+	family("migrate_queue_depth", "gauge", "Jobs waiting for a worker.", func(r StatusReport, _ *Stats, sample func(any, ...string)) {
+		sample(r.QueueDepth)
+	})
+	family("migrate_worker_in_flight", "gauge", "Jobs a worker is copying right now.", func(r StatusReport, _ *Stats, sample func(any, ...string)) {
+		for _, ws := range r.Workers {
+			active := 0
+			if ws.Active {
+				active = 1
+			}
+			sample(active, "worker", strconv.Itoa(ws.ID))
+		}
+	})
+
+	fmt.Fprintln(w, "# HELP migrate_copy_duration_seconds Time to copy and verify one object, retries included.")
+	fmt.Fprintln(w, "# TYPE migrate_copy_duration_seconds histogram")
+	for i, s := range runs {
+		s.copyDuration.write(w, "migrate_copy_duration_seconds", jobs[i])
+	}
+
+	family("migrate_elapsed_seconds", "gauge", "Time since the migration started.", func(r StatusReport, _ *Stats, sample func(any, ...string)) {
+		sample(r.Elapsed)
+	})
+}
+
+// metricLabels formats a label set, led by the job when there is one.
+// pairs alternate label names and values.
+func metricLabels(job string, pairs ...string) string {
+	var parts []string
+	if job != "" {
+		parts = append(parts, fmt.Sprintf("job=%q", job))
+	}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, fmt.Sprintf("%s=%q", pairs[i], pairs[i+1]))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// activeStats holds the runs the status server reports on
+var activeStats statsRegistry
+
+// startStatusServer serves /metrics and /status on addr until the
+// returned stop function is called
+func startStatusServer(addr string, logger *TimestampLogger) (func(), error) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		jobs, runs := activeStats.All()
+		writeMetrics(w, jobs, runs)
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		jobs, runs := activeStats.All()
+		if len(runs) == 0 {
+			http.Error(w, "no migration running", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		// Without jobs the report is the run's own, with jobs it is keyed
+		// by job name
+		if len(runs) == 1 && jobs[0] == "" {
+			enc.Encode(runs[0].Report())
+			return
+		}
+		reports := make(map[string]StatusReport, len(runs))
+		for i, stats := range runs {
+			reports[jobs[i]] = stats.Report()
+		}
+		enc.Encode(struct {
+			Jobs map[string]StatusReport `json:"jobs"`
+		}{reports})
+	})
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start status server: %w", err)
+	}
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("⚠ Status server stopped", "error", err)
+		}
+	}()
+	logger.Log("Status server on http://%s (/metrics, /status)", ln.Addr())
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}, nil
+}
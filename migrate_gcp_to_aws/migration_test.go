@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestLocalMigrationResumes runs the whole pipeline between two local
+// directories, then again after a crash left one copy unfinished
+func TestLocalMigrationResumes(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	config, err := LoadConfig("", map[string]string{
+		"source":      filepath.Join(root, "gcs"),
+		"destination": filepath.Join(root, "s3"),
+		"log_file":    filepath.Join(root, "migrate.log"),
+		"cutoff_date": "2025-09-01",
+		"max_workers": "2",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger, err := NewTimestampLogger(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	recordings := map[string]string{
+		"port1/2025-09-10/a.mp4": "first",
+		"port1/2025-09-10/b.mp4": "second",
+		"port2/2025-09-11/c.mp4": "third",
+		"port2/2025-08-01/d.mp4": "before the cutoff",
+		"port2/2025-09-11/c.jpg": "thumbnail",
+	}
+	for key, content := range recordings {
+		p := filepath.Join(config.Source, filepath.FromSlash(key))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	copied := func(key string) bool {
+		_, err := os.Stat(filepath.Join(config.Destination, filepath.FromSlash(key)))
+		return err == nil
+	}
+
+	run := func() {
+		t.Helper()
+		src, dst, err := openStores(ctx, config, logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		journal, err := OpenJournal(config.JournalFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer journal.Close()
+		if err := runMigration(ctx, config, src, dst, journal, logger, nil, newDeletionBudget(config), nil); err != nil {
+			t.Fatalf("migration: %v", err)
+		}
+	}
+
+	run()
+	for key, want := range recordings {
+		data, err := os.ReadFile(filepath.Join(config.Destination, filepath.FromSlash(key)))
+		switch key {
+		case "port2/2025-08-01/d.mp4", "port2/2025-09-11/c.jpg":
+			if err == nil {
+				t.Errorf("%s was copied", key)
+			}
+		default:
+			if err != nil || string(data) != want {
+				t.Errorf("%s: copied %q, %v, want %q", key, data, err, want)
+			}
+		}
+	}
+
+	// Simulate a run killed while copying b.mp4, and lose the verified
+	// copy of a.mp4 behind the journal's back
+	src, err := newLocalStore(config.Source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := src.Stat(ctx, "port1/2025-09-10/b.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal, err := OpenJournal(config.JournalFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := FileJob{GCSPath: info.Key, RelativePath: info.Key, Generation: info.Generation, Size: info.Size}
+	if err := journal.Record(job, JournalCopying, ""); err != nil {
+		t.Fatal(err)
+	}
+	journal.Close()
+	for _, key := range []string{"port1/2025-09-10/a.mp4", "port1/2025-09-10/b.mp4"} {
+		if err := os.Remove(filepath.Join(config.Destination, filepath.FromSlash(key))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run()
+	if copied("port1/2025-09-10/a.mp4") {
+		t.Error("object the journal has as verified was copied again")
+	}
+	if !copied("port1/2025-09-10/b.mp4") {
+		t.Error("interrupted copy was not resumed")
+	}
+
+	journal, err = OpenJournal(config.JournalFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+	if counts := journal.Counts(); counts[JournalVerified] != 3 || len(counts) != 1 {
+		t.Errorf("journal counts %v, want 3 verified", counts)
+	}
+}
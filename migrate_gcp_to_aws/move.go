@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DeletionRecord is one line of the deletion manifest: a source object
+// removed in move mode and the verified copy it was removed for, or a
+// destination object removed by sync_delete
+type DeletionRecord struct {
+	SourceStore      string     `json:"source_store,omitempty"`
+	Source           string     `json:"source,omitempty"`
+	Generation       int64      `json:"generation,omitempty"`
+	DestinationStore string     `json:"destination_store"`
+	Destination      string     `json:"destination"`
+	Size             int64      `json:"size"`
+	Reason           string     `json:"reason,omitempty"`
+	Verified         *time.Time `json:"verified,omitempty"`
+	Deleted          time.Time  `json:"deleted"`
+}
+
+// deletionLog appends to the deletion manifest, one fsynced line per
+// deleted object
+type deletionLog struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+func openDeletionLog(path string) (*deletionLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create deletion manifest directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open deletion manifest: %w", err)
+	}
+	return &deletionLog{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (d *deletionLog) Write(rec DeletionRecord) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.enc.Encode(rec); err != nil {
+		return fmt.Errorf("failed to write deletion manifest: %w", err)
+	}
+	return d.f.Sync()
+}
+
+func (d *deletionLog) Close() error {
+	return d.f.Close()
+}
+
+// deletionBudget is how many more objects a run may delete. Every
+// deletion pass of a run draws on the same budget, so together they
+// delete at most MaxDeletes objects per run.
+type deletionBudget struct {
+	mu   sync.Mutex
+	left int
+}
+
+func newDeletionBudget(config *Config) *deletionBudget {
+	return &deletionBudget{left: config.MaxDeletes}
+}
+
+// Take reserves up to n deletions and returns how many it got
+func (b *deletionBudget) Take(n int) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n = min(n, b.left)
+	b.left -= n
+	return n
+}
+
+// moveStats counts the outcome of the deletion pass
+type moveStats struct {
+	deleted      atomic.Int64
+	deletedBytes atomic.Int64
+	changed      atomic.Int64
+	unverified   atomic.Int64
+	errors       atomic.Int64
+}
+
+// runDeletions removes the source of every object the journal has as
+// verified for at least the safety delay, as many as budget allows. Each copy
+// is checked again right before its source goes, and the source is only
+// deleted if it is still the generation that was copied. Anything left
+// out stays verified in the journal and is picked up by a later run.
+func runDeletions(
+	ctx context.Context,
+	config *Config,
+	src ObjectStore,
+	dst ObjectStore,
+	journal *Journal,
+	budget *deletionBudget,
+	logger *TimestampLogger,
+) error {
+	if !config.Move {
+		return nil
+	}
+
+	now := time.Now()
+	var due []JournalEntry
+	waiting := 0
+	for _, entry := range journal.Entries() {
+		if entry.State != JournalVerified {
+			continue
+		}
+		if now.Sub(entry.Time) < config.MoveDelay {
+			waiting++
+			continue
+		}
+		due = append(due, entry)
+	}
+
+	logger.Log("")
+	logger.Log("=== Deleting Moved Sources ===")
+	logger.Log("%d verified sources due for deletion, %d still inside the %s safety delay",
+		len(due), waiting, config.MoveDelay)
+	if n := budget.Take(len(due)); n < len(due) {
+		logger.Warn("⚠ Deletion cap reached, the rest wait for the next run", "deleting", n, "due", len(due), "max_deletes", config.MaxDeletes)
+		due = due[:n]
+	}
+	if len(due) == 0 {
+		return nil
+	}
+	logger.Log("Deletion manifest: %s", config.DeletionManifest)
+	logger.Log("")
+
+	deletions, err := openDeletionLog(config.DeletionManifest)
+	if err != nil {
+		return err
+	}
+	defer deletions.Close()
+
+	work := make(chan JournalEntry, config.MaxWorkers*2)
+	stats := &moveStats{}
+
+	var wg sync.WaitGroup
+	for i := 0; i < config.MaxWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for entry := range work {
+				deleteSource(ctx, entry, src, dst, journal, deletions, stats, logger)
+			}
+		}()
+	}
+
+feed:
+	for _, entry := range due {
+		select {
+		case work <- entry:
+		case <-shutdown.Stopping():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	logger.Log("")
+	logger.Log("  ✓ Sources deleted: %d (%s)", stats.deleted.Load(), formatBytes(stats.deletedBytes.Load()))
+	logger.Log("  ⊘ Kept, source changed since the copy: %d", stats.changed.Load())
+	logger.Log("  ✗ Kept, copy failed re-verification: %d", stats.unverified.Load())
+	logger.Log("  ✗ Errors: %d", stats.errors.Load())
+
+	if shutdown.Requested() {
+		return errInterrupted
+	}
+	if bad := stats.unverified.Load() + stats.errors.Load(); bad > 0 {
+		return fmt.Errorf("%d sources could not be deleted", bad)
+	}
+	return nil
+}
+
+// deleteSource re-verifies one copy with the quick check of verifyObject
+// and deletes its source. A multipart S3 copy is checked by size only,
+// its digests were checked once when it was copied.
+func deleteSource(
+	ctx context.Context,
+	entry JournalEntry,
+	src ObjectStore,
+	dst ObjectStore,
+	journal *Journal,
+	deletions *deletionLog,
+	stats *moveStats,
+	logger *TimestampLogger,
+) {
+	manifestEntry := entry.ManifestEntry()
+	if err := verifyObject(ctx, manifestEntry, src, dst, false); err != nil {
+		if errors.Is(err, errSourceMissing) {
+			logger.Warn("⊘ Not deleting, source unavailable", "key", entry.Name, "error", err)
+			stats.changed.Add(1)
+		} else {
+			logger.Error("✗ Not deleting, copy failed re-verification", "key", entry.Name, "error", err)
+			stats.unverified.Add(1)
+		}
+		return
+	}
+
+	if err := src.Delete(ctx, entry.Name, entry.Generation); err != nil {
+		if errors.Is(err, errGenerationMismatch) {
+			logger.Warn("⊘ Not deleting, source changed since it was copied", "key", entry.Name, "generation", entry.Generation)
+			stats.changed.Add(1)
+		} else {
+			logger.Error("✗ Could not delete source", "key", entry.Name, "error", err)
+			stats.errors.Add(1)
+		}
+		return
+	}
+
+	logger.Detail("✓ Deleted source", "key", entry.Name, "generation", entry.Generation,
+		"destination", entry.Destination, "size", entry.Size)
+	stats.deleted.Add(1)
+	stats.deletedBytes.Add(entry.Size)
+	if err := deletions.Write(DeletionRecord{
+		SourceStore:      src.URI(),
+		Source:           entry.Name,
+		Generation:       entry.Generation,
+		DestinationStore: dst.URI(),
+		Destination:      entry.Destination,
+		Size:             entry.Size,
+		Verified:         &entry.Time,
+		Deleted:          time.Now().UTC(),
+	}); err != nil {
+		logger.Warn("⚠ Deletion manifest write failed", "key", entry.Name, "error", err)
+	}
+	if err := journal.Record(manifestEntry.Job(), JournalDeleted, ""); err != nil {
+		logger.Warn("⚠ Journal write failed", "key", entry.Name, "error", err)
+	}
+}
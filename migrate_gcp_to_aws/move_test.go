@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// moveFixture is a source and destination directory with a journal that
+// has every object as verified
+type moveFixture struct {
+	src, dst *localStore
+	journal  *Journal
+	logger   *TimestampLogger
+	dir      string
+}
+
+func newMoveFixture(t *testing.T, objects map[string]string) *moveFixture {
+	t.Helper()
+	ctx := context.Background()
+	dir := t.TempDir()
+	f := &moveFixture{dir: dir}
+	var err error
+	if f.src, err = newLocalStore(filepath.Join(dir, "src")); err != nil {
+		t.Fatal(err)
+	}
+	if f.dst, err = newLocalStore(filepath.Join(dir, "dst")); err != nil {
+		t.Fatal(err)
+	}
+	if f.journal, err = OpenJournal(filepath.Join(dir, "migrate_journal.jsonl")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.journal.Close() })
+	if f.logger, err = NewTimestampLogger(&Config{LogFile: filepath.Join(dir, "migrate.log")}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.logger.Close() })
+
+	for key, content := range objects {
+		for _, store := range []*localStore{f.src, f.dst} {
+			if _, err := store.Put(ctx, key, bytes.NewReader([]byte(content)), PutOptions{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		info, err := f.src.Stat(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		job := FileJob{GCSPath: key, RelativePath: key, Generation: info.Generation, Size: info.Size}
+		if err := f.journal.Record(job, JournalVerified, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return f
+}
+
+func (f *moveFixture) config(t *testing.T, overrides map[string]string) *Config {
+	t.Helper()
+	settings := map[string]string{
+		"log_file":          filepath.Join(f.dir, "migrate.log"),
+		"deletion_manifest": filepath.Join(f.dir, "deletions.jsonl"),
+		"move":              "true",
+		"move_delay":        "0s",
+		"max_workers":       "2",
+	}
+	for k, v := range overrides {
+		settings[k] = v
+	}
+	config, err := LoadConfig("", settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func (f *moveFixture) exists(store *localStore, key string) bool {
+	_, err := store.Stat(context.Background(), key)
+	return err == nil
+}
+
+func TestMoveDeletesVerifiedSources(t *testing.T) {
+	f := newMoveFixture(t, map[string]string{
+		"port1/2025-09-10/a.mp4": "good copy",
+		"port1/2025-09-10/b.mp4": "copy lost bytes",
+		"port1/2025-09-10/c.mp4": "re-recorded later",
+	})
+	ctx := context.Background()
+
+	// b's copy was truncated, c was rewritten at the source after its copy
+	if _, err := f.dst.Put(ctx, "port1/2025-09-10/b.mp4", bytes.NewReader([]byte("copy")), PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	c := filepath.Join(f.src.root, "port1", "2025-09-10", "c.mp4")
+	if err := os.WriteFile(c, []byte("RE-RECORDED LATER"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(c, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	config := f.config(t, nil)
+	err := runDeletions(ctx, config, f.src, f.dst, f.journal, newDeletionBudget(config), f.logger)
+	if err == nil {
+		t.Error("a copy failing re-verification was not reported")
+	}
+
+	if f.exists(f.src, "port1/2025-09-10/a.mp4") {
+		t.Error("source of a verified copy was not deleted")
+	}
+	if !f.exists(f.dst, "port1/2025-09-10/a.mp4") {
+		t.Error("copy was deleted")
+	}
+	if !f.exists(f.src, "port1/2025-09-10/b.mp4") {
+		t.Error("source was deleted although its copy no longer verifies")
+	}
+	if !f.exists(f.src, "port1/2025-09-10/c.mp4") {
+		t.Error("source was deleted although it changed since the copy")
+	}
+
+	records, err := os.ReadFile(config.DeletionManifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(records, []byte("\n")); n != 1 || !bytes.Contains(records, []byte(`"source":"port1/2025-09-10/a.mp4"`)) {
+		t.Errorf("deletion manifest:\n%s\nwant one record for a.mp4", records)
+	}
+	// Kept sources stay verified for a later run
+	if counts := f.journal.Counts(); counts[JournalDeleted] != 1 || counts[JournalVerified] != 2 {
+		t.Errorf("journal counts %v, want 1 deleted and 2 verified", counts)
+	}
+}
+
+func TestMoveSafetyDelayAndCap(t *testing.T) {
+	f := newMoveFixture(t, map[string]string{
+		"port1/2025-09-10/a.mp4": "a",
+		"port1/2025-09-10/b.mp4": "b",
+		"port1/2025-09-10/c.mp4": "c",
+	})
+	ctx := context.Background()
+	remaining := func() int {
+		n := 0
+		for _, key := range []string{"port1/2025-09-10/a.mp4", "port1/2025-09-10/b.mp4", "port1/2025-09-10/c.mp4"} {
+			if f.exists(f.src, key) {
+				n++
+			}
+		}
+		return n
+	}
+
+	config := f.config(t, map[string]string{"move_delay": "24h"})
+	if err := runDeletions(ctx, config, f.src, f.dst, f.journal, newDeletionBudget(config), f.logger); err != nil {
+		t.Fatal(err)
+	}
+	if n := remaining(); n != 3 {
+		t.Fatalf("%d sources left inside the safety delay, want 3", n)
+	}
+
+	// One budget per run: a second pass in the same run deletes nothing
+	config = f.config(t, map[string]string{"max_deletes": "2"})
+	budget := newDeletionBudget(config)
+	for i := 0; i < 2; i++ {
+		if err := runDeletions(ctx, config, f.src, f.dst, f.journal, budget, f.logger); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := remaining(); n != 1 {
+		t.Errorf("%d sources left with max_deletes 2, want 1", n)
+	}
+
+	// The next run picks up the rest
+	if err := runDeletions(ctx, config, f.src, f.dst, f.journal, newDeletionBudget(config), f.logger); err != nil {
+		t.Fatal(err)
+	}
+	if n := remaining(); n != 0 {
+		t.Errorf("%d sources left after the next run, want 0", n)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// S3 upload limits
+const (
+	s3MinPartSize = s3manager.MinUploadPartSize // 5 MiB, except the last part
+	s3MaxPartSize = 5 << 30                     // also the largest single PUT
+	s3MaxParts    = s3manager.MaxUploadParts    // 10,000
+	partSizeAlign = 1 << 20
+)
+
+// UploadPlan is how an object is written to S3: in a single PUT, or in
+// Parts parts of PartSize bytes
+type UploadPlan struct {
+	Multipart bool
+	PartSize  int64
+	Parts     int64
+}
+
+// Method names the plan for reports, "single" or "multipart"
+func (p UploadPlan) Method() string {
+	if p.Multipart {
+		return "multipart"
+	}
+	return "single"
+}
+
+func (p UploadPlan) String() string {
+	if !p.Multipart {
+		return "single PUT"
+	}
+	if p.Parts == 0 {
+		return "multipart, parts of " + formatBytes(p.PartSize)
+	}
+	return fmt.Sprintf("multipart, %d parts of %s", p.Parts, formatBytes(p.PartSize))
+}
+
+// PartSizing picks the upload plan of each object from its size. Objects
+// under SinglePutMax go up in one PUT. Larger ones are split into the
+// smallest parts, at least MinPart, that keep them within S3's 10,000
+// parts, rounded up to a whole MiB. An object that would need parts over
+// MaxPart is refused before anything is sent.
+type PartSizing struct {
+	MinPart      int64
+	MaxPart      int64
+	SinglePutMax int64
+}
+
+// Plan decides how an object of size bytes is uploaded. A negative size
+// means unknown, the object is then sent in parts of MinPart.
+func (ps PartSizing) Plan(size int64) (UploadPlan, error) {
+	if size < 0 {
+		return UploadPlan{Multipart: true, PartSize: ps.MinPart}, nil
+	}
+	if size < ps.SinglePutMax {
+		// The uploader sends a single PUT when the body fits in its first
+		// part buffer
+		return UploadPlan{PartSize: max(size+1, s3MinPartSize), Parts: 1}, nil
+	}
+
+	part := (size + s3MaxParts - 1) / s3MaxParts
+	part = (part + partSizeAlign - 1) / partSizeAlign * partSizeAlign
+	part = max(part, ps.MinPart)
+	if part > ps.MaxPart {
+		// EntityTooLarge makes the error permanent, retrying cannot help
+		return UploadPlan{}, awserr.New("EntityTooLarge", fmt.Sprintf(
+			"object of %s needs parts of %s to stay within %d parts, above max_part_size %s",
+			formatBytes(size), formatBytes(part), s3MaxParts, formatBytes(ps.MaxPart)), nil)
+	}
+	return UploadPlan{Multipart: true, PartSize: part, Parts: (size + part - 1) / part}, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import "testing"
+
+func TestPartSizingPlan(t *testing.T) {
+	sizing := PartSizing{MinPart: 10 << 20, MaxPart: 5 << 30, SinglePutMax: 64 << 20}
+	tests := []struct {
+		name      string
+		size      int64
+		multipart bool
+		partSize  int64
+		parts     int64
+	}{
+		{"unknown size", -1, true, 10 << 20, 0},
+		{"empty", 0, false, s3MinPartSize, 1},
+		{"small clip", 1000, false, s3MinPartSize, 1},
+		{"just under the threshold", 64<<20 - 1, false, 64 << 20, 1},
+		{"at the threshold", 64 << 20, true, 10 << 20, 7},
+		{"fits minimum parts", 100e9, true, 10 << 20, 9537},
+		{"needs bigger parts", 200e9, true, 20 << 20, 9537},
+		{"4 TB recording", 4e12, true, 382 << 20, 9987},
+	}
+	for _, tt := range tests {
+		plan, err := sizing.Plan(tt.size)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if plan.Multipart != tt.multipart || plan.PartSize != tt.partSize || plan.Parts != tt.parts {
+			t.Errorf("%s: Plan(%d) = %+v, want multipart %v, %d parts of %d",
+				tt.name, tt.size, plan, tt.multipart, tt.parts, tt.partSize)
+		}
+		if plan.Parts > s3MaxParts {
+			t.Errorf("%s: %d parts, over the S3 limit", tt.name, plan.Parts)
+		}
+		if plan.Multipart && plan.PartSize%partSizeAlign != 0 {
+			t.Errorf("%s: part size %d is not a whole MiB", tt.name, plan.PartSize)
+		}
+	}
+}
+
+func TestPartSizingPlanTooLarge(t *testing.T) {
+	sizing := PartSizing{MinPart: 10 << 20, MaxPart: 100 << 20, SinglePutMax: 64 << 20}
+	_, err := sizing.Plan(2e12)
+	if err == nil {
+		t.Fatal("object needing parts over max_part_size was accepted")
+	}
+	if class := classifyError(err); class != classPermanent {
+		t.Errorf("error class %s, want %s so it is not retried", class, classPermanent)
+	}
+}
+
+func TestPartSizingConfig(t *testing.T) {
+	config, err := LoadConfig("", map[string]string{"multipart_threshold": "100MB", "min_part_size": "16MiB"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := PartSizing{MinPart: 16 << 20, MaxPart: 5 << 30, SinglePutMax: 100e6}
+	if got := config.PartSizing(); got != want {
+		t.Errorf("PartSizing() = %+v, want %+v", got, want)
+	}
+
+	for _, bad := range []map[string]string{
+		{"min_part_size": "1MiB"},
+		{"max_part_size": "6GiB"},
+		{"min_part_size": "64MiB", "max_part_size": "32MiB"},
+		{"multipart_threshold": "10GiB"},
+	} {
+		if _, err := LoadConfig("", bad); err == nil {
+			t.Errorf("%v was accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Decision is what a run would do with an object
+type Decision string
+
+const (
+	DecisionCopy          Decision = "copy"
+	DecisionSkipExists    Decision = "skip-exists"
+	DecisionSkipDate      Decision = "skip-date"
+	DecisionSkipExtension Decision = "skip-extension"
+	DecisionSkipFilter    Decision = "skip-filter"
+	DecisionKeyError      Decision = "key-error"
+	// DecisionDeleteExtra is a destination object with no source, removed
+	// in sync mode with sync_delete
+	DecisionDeleteExtra Decision = "delete-extra"
+)
+
+// ManifestEntry is one object in a migration plan
+type ManifestEntry struct {
+	Source      string   `json:"source"`
+	Generation  int64    `json:"generation"`
+	Destination string   `json:"destination"`
+	Size        int64    `json:"size"`
+	Date        string   `json:"date,omitempty"`
+	Decision    Decision `json:"decision"`
+	Reason      string   `json:"reason,omitempty"`
+	// S3Options are the resolved destination options of an object to copy
+	S3Options *DestinationOptions `json:"s3_options,omitempty"`
+}
+
+var manifestHeader = []string{"source", "generation", "destination", "size", "date", "decision", "reason", "s3_options"}
+
+// Job turns an approved manifest entry back into a FileJob
+func (e ManifestEntry) Job() FileJob {
+	date, _ := time.Parse("2006-01-02", e.Date)
+	return FileJob{
+		GCSPath:      e.Source,
+		RelativePath: e.Destination,
+		CreatedTime:  date,
+		Generation:   e.Generation,
+		Size:         e.Size,
+		S3Options:    e.S3Options,
+	}
+}
+
+// compile checks options that may have been edited in a manifest
+func (e *ManifestEntry) compile() error {
+	if e.S3Options == nil {
+		return nil
+	}
+	if err := e.S3Options.compile(); err != nil {
+		return fmt.Errorf("s3_options: %w", err)
+	}
+	return nil
+}
+
+// planObject applies the same filters and date window as a real run.
+// Destination existence is checked separately since it needs the store.
+func planObject(info *ObjectInfo, config *Config) ManifestEntry {
+	entry := ManifestEntry{
+		Source:     info.Key,
+		Generation: info.Generation,
+		Size:       info.Size,
+	}
+
+	if config.Include == nil && !isVideoFile(info.Key, config.VideoExtensions) {
+		entry.Destination, _ = config.DestinationKey(info.Key, time.Time{})
+		entry.Decision = DecisionSkipExtension
+		return entry
+	}
+	if !config.Selected(info, time.Now()) {
+		entry.Destination, _ = config.DestinationKey(info.Key, time.Time{})
+		entry.Decision = DecisionSkipFilter
+		return entry
+	}
+
+	date, err := config.ObjectDate(info)
+	if err != nil {
+		entry.Destination, _ = config.DestinationKey(info.Key, time.Time{})
+		entry.Decision = DecisionSkipDate
+		entry.Reason = err.Error()
+		return entry
+	}
+	entry.Date = date.In(config.Location).Format("2006-01-02")
+
+	destination, keyErr := config.DestinationKey(info.Key, date)
+	entry.Destination = destination
+
+	if !config.InWindow(date) {
+		entry.Decision = DecisionSkipDate
+		entry.Reason = fmt.Sprintf("file dated %s (outside %s)", entry.Date, config.DateWindow())
+		return entry
+	}
+
+	if keyErr != nil {
+		entry.Decision = DecisionKeyError
+		entry.Reason = keyErr.Error()
+		return entry
+	}
+
+	entry.Decision = DecisionCopy
+	entry.S3Options = config.DestinationOptions(info.Key, destination, date)
+	return entry
+}
+
+// keyClaims tracks which source each destination key was handed to, so
+// two objects are never written to the same key
+type keyClaims map[string]string
+
+// claim records source for its destination, or returns the source that
+// already has it
+func (k keyClaims) claim(destination, source string) (string, bool) {
+	if other, ok := k[destination]; ok && other != source {
+		return other, false
+	}
+	k[destination] = source
+	return "", true
+}
+
+// ManifestWriter writes manifest entries as JSONL, or as CSV when the path
+// ends in .csv
+type ManifestWriter struct {
+	f   *os.File
+	buf *bufio.Writer
+	csv *csv.Writer
+	enc *json.Encoder
+}
+
+func NewManifestWriter(path string) (*ManifestWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create manifest: %w", err)
+	}
+
+	mw := &ManifestWriter{f: f, buf: bufio.NewWriter(f)}
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		mw.csv = csv.NewWriter(mw.buf)
+		if err := mw.csv.Write(manifestHeader); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to write manifest: %w", err)
+		}
+	} else {
+		mw.enc = json.NewEncoder(mw.buf)
+	}
+	return mw, nil
+}
+
+func (mw *ManifestWriter) Write(e ManifestEntry) error {
+	if mw.csv != nil {
+		var options string
+		if e.S3Options != nil {
+			data, err := json.Marshal(e.S3Options)
+			if err != nil {
+				return err
+			}
+			options = string(data)
+		}
+		return mw.csv.Write([]string{
+			e.Source,
+			strconv.FormatInt(e.Generation, 10),
+			e.Destination,
+			strconv.FormatInt(e.Size, 10),
+			e.Date,
+			string(e.Decision),
+			e.Reason,
+			options,
+		})
+	}
+	return mw.enc.Encode(e)
+}
+
+func (mw *ManifestWriter) Close() error {
+	if mw.csv != nil {
+		mw.csv.Flush()
+		if err := mw.csv.Error(); err != nil {
+			mw.f.Close()
+			return fmt.Errorf("failed to write manifest: %w", err)
+		}
+	}
+	if err := mw.buf.Flush(); err != nil {
+		mw.f.Close()
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return mw.f.Close()
+}
+
+// ReadManifest loads a manifest written by plan mode, JSONL or CSV
+func ReadManifest(path string) ([]ManifestEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open manifest: %w", err)
+	}
+	defer f.Close()
+
+	var entries []ManifestEntry
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		r := csv.NewReader(f)
+		// Manifests from before s3_options have one column less
+		r.FieldsPerRecord = -1
+		records, err := r.ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse manifest: %w", err)
+		}
+		for i, rec := range records {
+			if i == 0 || len(rec) < len(manifestHeader)-1 {
+				continue
+			}
+			generation, err := strconv.ParseInt(rec[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("manifest line %d: invalid generation: %w", i+1, err)
+			}
+			size, err := strconv.ParseInt(rec[3], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("manifest line %d: invalid size: %w", i+1, err)
+			}
+			entry := ManifestEntry{
+				Source:      rec[0],
+				Generation:  generation,
+				Destination: rec[2],
+				Size:        size,
+				Date:        rec[4],
+				Decision:    Decision(rec[5]),
+				Reason:      rec[6],
+			}
+			if len(rec) > 7 && rec[7] != "" {
+				if err := json.Unmarshal([]byte(rec[7]), &entry.S3Options); err != nil {
+					return nil, fmt.Errorf("manifest line %d: invalid s3_options: %w", i+1, err)
+				}
+			}
+			if err := entry.compile(); err != nil {
+				return nil, fmt.Errorf("manifest line %d: %w", i+1, err)
+			}
+			entries = append(entries, entry)
+		}
+		return entries, nil
+	}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var entry ManifestEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("manifest line %d: %w", line, err)
+		}
+		if err := entry.compile(); err != nil {
+			return nil, fmt.Errorf("manifest line %d: %w", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	return entries, nil
+}
+
+// planTotal counts objects and bytes for one decision
+type planTotal struct {
+	files int
+	bytes int64
+}
+
+// runPlan lists the source, decides what a real run would do with every
+// object and writes the result to a manifest without copying anything
+func runPlan(
+	ctx context.Context,
+	config *Config,
+	src ObjectStore,
+	dst ObjectStore,
+	logger *TimestampLogger,
+	manifestPath string,
+) error {
+	mw, err := NewManifestWriter(manifestPath)
+	if err != nil {
+		return err
+	}
+
+	logger.Log("Planning migration from %s to %s...", src.URI(), dst.URI())
+	logger.Log("Manifest: %s", manifestPath)
+	logger.Log("S3 options: %s", config.S3Options.String())
+
+	candidates := make(chan ManifestEntry, config.MaxWorkers*2)
+	results := make(chan ManifestEntry, config.MaxWorkers*2)
+
+	// Destination checks are one HEAD each, so spread them over the workers
+	var wg sync.WaitGroup
+	for i := 0; i < config.MaxWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for entry := range candidates {
+				if entry.Decision == DecisionCopy {
+					if needed, reason := needsCopy(ctx, config, src, dst, entry.Source, entry.Destination); !needed {
+						entry.Decision = DecisionSkipExists
+					} else if reason != "" {
+						entry.Reason = "destination differs: " + reason
+					}
+				}
+				results <- entry
+			}
+		}()
+	}
+
+	var listErr error
+	go func() {
+		defer close(candidates)
+		listErr = listSource(ctx, config, src, logger, func(info *ObjectInfo) error {
+			if shutdown.Requested() {
+				return errInterrupted
+			}
+			candidates <- planObject(info, config)
+			return nil
+		})
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	totals := make(map[Decision]*planTotal)
+	// Objects to copy by the destination options they get
+	options := make(map[string]*planTotal)
+	claims := keyClaims{}
+	keep := map[string]bool{}
+	var writeErr error
+	for entry := range results {
+		keep[entry.Destination] = true
+		if entry.Decision == DecisionCopy || entry.Decision == DecisionSkipExists {
+			if other, ok := claims.claim(entry.Destination, entry.Source); !ok {
+				entry.Decision = DecisionKeyError
+				entry.Reason = fmt.Sprintf("destination %s collides with %s", entry.Destination, other)
+			}
+		}
+		if writeErr == nil {
+			writeErr = mw.Write(entry)
+		}
+		t := totals[entry.Decision]
+		if t == nil {
+			t = &planTotal{}
+			totals[entry.Decision] = t
+		}
+		t.files++
+		t.bytes += entry.Size
+		if entry.Decision == DecisionCopy {
+			o := options[entry.S3Options.String()]
+			if o == nil {
+				o = &planTotal{}
+				options[entry.S3Options.String()] = o
+			}
+			o.files++
+			o.bytes += entry.Size
+		}
+	}
+	// Sync deletions are only planned from a complete listing
+	if config.SyncDelete && listErr == nil && writeErr == nil {
+		extras, err := findExtras(ctx, dst, config.SyncDeleteScope, keep)
+		if err != nil {
+			mw.Close()
+			return err
+		}
+		for _, entry := range extras {
+			if writeErr = mw.Write(entry); writeErr != nil {
+				break
+			}
+			t := totals[entry.Decision]
+			if t == nil {
+				t = &planTotal{}
+				totals[entry.Decision] = t
+			}
+			t.files++
+			t.bytes += entry.Size
+		}
+	}
+	if err := mw.Close(); err != nil && writeErr == nil {
+		writeErr = err
+	}
+	if writeErr != nil {
+		return fmt.Errorf("failed to write manifest: %w", writeErr)
+	}
+	// A partial listing would understate the plan, so do not hand it out
+	if listErr != nil {
+		return fmt.Errorf("error listing %s: %w", src.URI(), listErr)
+	}
+
+	logger.Log("")
+	logger.Log("========================================")
+	logger.Log("            MIGRATION PLAN              ")
+	logger.Log("========================================")
+	logger.Log("")
+	for _, decision := range []Decision{DecisionCopy, DecisionSkipExists, DecisionSkipDate, DecisionSkipExtension, DecisionSkipFilter, DecisionKeyError, DecisionDeleteExtra} {
+		t := totals[decision]
+		if t == nil {
+			t = &planTotal{}
+		}
+		logger.Log("  %-15s %8d files  %12s", decision, t.files, formatBytes(t.bytes))
+	}
+	if len(options) > 0 {
+		logger.Log("")
+		logger.Log("Objects to copy by S3 options:")
+		descriptions := make([]string, 0, len(options))
+		for description := range options {
+			descriptions = append(descriptions, description)
+		}
+		sort.Strings(descriptions)
+		for _, description := range descriptions {
+			o := options[description]
+			logger.Log("  %8d files  %12s  %s", o.files, formatBytes(o.bytes), description)
+		}
+	}
+	logger.Log("")
+	logger.Log("Manifest written to %s", manifestPath)
+	logger.Log("========================================")
+
+	if t := totals[DecisionKeyError]; t != nil {
+		return fmt.Errorf("%d objects have no usable destination key, see the manifest", t.files)
+	}
+	return nil
+}
+
+// formatBytes renders a byte count with a binary unit
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.2f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Progress display modes
+const (
+	progressAuto = "auto"
+	progressBar  = "bar"
+	progressLog  = "log"
+	progressOff  = "off"
+)
+
+// throughputWindow is roughly how far back the throughput average looks
+const throughputWindow = 30 * time.Second
+
+// maxStatusWorkers is how many workers the terminal bar lists
+const maxStatusWorkers = 10
+
+// Progress is a snapshot of how far a run has got. Totals grow while the
+// source is still being scanned, TotalsKnown says they are final.
+type Progress struct {
+	Files       int64         `json:"files_done"`
+	FilesTotal  int64         `json:"files_total"`
+	Bytes       int64         `json:"bytes_done"`
+	BytesTotal  int64         `json:"bytes_total"`
+	TotalsKnown bool          `json:"totals_known"`
+	Throughput  float64       `json:"throughput_bytes_per_second"`
+	ETA         time.Duration `json:"-"`
+	ETASeconds  float64       `json:"eta_seconds,omitempty"`
+}
+
+// Progress snapshots the run: objects finished whatever their outcome,
+// and bytes finished plus what active workers have read so far
+func (s *Stats) Progress() Progress {
+	p := Progress{
+		Files: s.copiedFiles.Load() + s.skippedExisting.Load() + s.errorFiles.Load() +
+			s.checksumErrors.Load() + s.interrupted.Load(),
+		FilesTotal:  s.queued.Load(),
+		Bytes:       s.copiedBytes.Load() + s.skippedBytes.Load() + s.failedBytes.Load(),
+		BytesTotal:  s.queuedBytes.Load(),
+		TotalsKnown: s.scanDone.Load(),
+		Throughput:  s.throughput.Rate(),
+	}
+	for _, ws := range s.Workers() {
+		if ws.Active {
+			p.Bytes += min(ws.Transferred, ws.Size)
+		}
+	}
+	p.Bytes = min(p.Bytes, p.BytesTotal)
+	if p.Throughput > 0 {
+		p.ETA = time.Duration(float64(p.BytesTotal-p.Bytes) / p.Throughput * float64(time.Second))
+		p.ETASeconds = math.Round(p.ETA.Seconds())
+	}
+	return p
+}
+
+// Percent is the share of the bytes done, of the totals known so far
+func (p Progress) Percent() float64 {
+	if p.BytesTotal == 0 {
+		if p.FilesTotal == 0 {
+			return 0
+		}
+		return 100 * float64(p.Files) / float64(p.FilesTotal)
+	}
+	return 100 * float64(p.Bytes) / float64(p.BytesTotal)
+}
+
+// Summary is a one-line description, e.g. "120/300+ objects, 1.20 GiB of
+// 2.91 GiB (41%), 85.3 MB/s, ETA 3m20s". A "+" marks totals that are
+// still growing, and the ETA is then a lower bound.
+func (p Progress) Summary() string {
+	more := ""
+	if !p.TotalsKnown {
+		more = "+"
+	}
+	eta := "unknown"
+	if p.Throughput > 0 {
+		eta = p.ETA.Round(time.Second).String()
+		if !p.TotalsKnown {
+			eta = "at least " + eta
+		}
+	}
+	return fmt.Sprintf("%d/%d%s objects, %s of %s%s (%.0f%%), %s, ETA %s",
+		p.Files, p.FilesTotal, more, formatBytes(p.Bytes), formatBytes(p.BytesTotal), more,
+		p.Percent(), formatRate(p.Throughput), eta)
+}
+
+// formatRate shows bytes per second in MB/s
+func formatRate(bytesPerSec float64) string {
+	return fmt.Sprintf("%.1f MB/s", bytesPerSec/1e6)
+}
+
+// throughputMeter keeps an exponentially weighted moving average of the
+// byte rate, fed with the running byte count. Until a full window has
+// passed it is the plain average since the first observation, so early
+// estimates are not dragged towards zero.
+type throughputMeter struct {
+	mu    sync.Mutex
+	first time.Time
+	last  time.Time
+	bytes int64
+	rate  float64
+}
+
+// Observe records the byte count at now
+func (m *throughputMeter) Observe(now time.Time, bytes int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.last.IsZero() {
+		m.first, m.last, m.bytes = now, now, bytes
+		return
+	}
+	dt := now.Sub(m.last)
+	if dt <= 0 {
+		return
+	}
+	instant := float64(bytes-m.bytes) / dt.Seconds()
+	alpha := max(1-math.Exp(-dt.Seconds()/throughputWindow.Seconds()), dt.Seconds()/now.Sub(m.first).Seconds())
+	m.rate += alpha * (instant - m.rate)
+	m.last, m.bytes = now, bytes
+}
+
+// Rate is the average in bytes per second
+func (m *throughputMeter) Rate() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.rate
+}
+
+// progressReader counts the bytes read through it
+type progressReader struct {
+	r       io.Reader
+	counted func(n int)
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	if n > 0 {
+		pr.counted(n)
+	}
+	return n, err
+}
+
+// monitorProgress reports on the run until the returned stop function is
+// called: as a live bar on a terminal, or as log lines every
+// ProgressInterval
+func monitorProgress(config *Config, stats *Stats, logger *TimestampLogger) func() {
+	mode := config.Progress
+	if mode == progressAuto {
+		mode = progressLog
+		if config.LogFormat == logFormatText && isTerminal(os.Stdout) {
+			mode = progressBar
+		}
+	}
+
+	stats.throughput.Observe(time.Now(), stats.transferredBytes.Load())
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		lastLog := time.Now()
+		for {
+			select {
+			case now := <-ticker.C:
+				stats.throughput.Observe(now, stats.transferredBytes.Load())
+				switch mode {
+				case progressBar:
+					console.SetStatus(config.Job, statusLines(config.Job, stats, logger.quiet))
+				case progressLog:
+					if now.Sub(lastLog) >= config.ProgressInterval {
+						lastLog = now
+						logProgress(stats, logger)
+					}
+				}
+			case <-done:
+				console.SetStatus(config.Job, nil)
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// logProgress writes a progress update to the log
+func logProgress(stats *Stats, logger *TimestampLogger) {
+	p := stats.Progress()
+	logger.Log("")
+	logger.Log("⏱ Progress Update (%s elapsed): %s", time.Since(stats.started).Round(time.Second), p.Summary())
+	logger.Log("   ✓ Copied: %d", stats.copiedFiles.Load())
+	logger.Log("   ⊘ Skipped (already exist): %d", stats.skippedExisting.Load())
+	logger.Log("   ✗ Errors: %d", stats.errorFiles.Load())
+	logger.Log("   ✗ Checksum mismatches: %d", stats.checksumErrors.Load())
+	logger.Log("   ↻ Retries: %d", stats.retries.Load())
+	if !logger.quiet {
+		for _, ws := range stats.Workers() {
+			if ws.Active {
+				logger.Log("   ⬆ [%d] %s %s of %s", ws.ID, ws.Key, formatBytes(ws.Transferred), formatBytes(ws.Size))
+			}
+		}
+	}
+	logger.Log("")
+}
+
+// statusLines draws the terminal bar of a run, with a line per active
+// worker unless quiet
+func statusLines(job string, stats *Stats, quiet bool) []string {
+	p := stats.Progress()
+	const width = 30
+	filled := int(p.Percent() / 100 * width)
+	bar := strings.Repeat("=", filled)
+	if filled < width {
+		bar += ">" + strings.Repeat(" ", width-filled-1)
+	}
+	if job != "" {
+		bar = job + " [" + bar + "]"
+	} else {
+		bar = "[" + bar + "]"
+	}
+	lines := []string{bar + " " + p.Summary()}
+	if quiet {
+		return lines
+	}
+
+	active := 0
+	for _, ws := range stats.Workers() {
+		if !ws.Active {
+			continue
+		}
+		active++
+		if active <= maxStatusWorkers {
+			lines = append(lines, fmt.Sprintf("  [%d] %s %s of %s", ws.ID, ws.Key, formatBytes(ws.Transferred), formatBytes(ws.Size)))
+		}
+	}
+	if active > maxStatusWorkers {
+		lines = append(lines, fmt.Sprintf("  ... and %d more workers", active-maxStatusWorkers))
+	}
+	return lines
+}
+
+// isTerminal reports whether f is a character device such as a terminal
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// console is standard output. While a status display is up, anything
+// written goes above it and the display is drawn again underneath. Each
+// job of a multi-job run has its own section of the display.
+var console = &consoleWriter{out: os.Stdout}
+
+type consoleWriter struct {
+	mu       sync.Mutex
+	out      io.Writer
+	sections map[string][]string
+	status   []string
+}
+
+func (c *consoleWriter) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clear()
+	n, err := c.out.Write(p)
+	c.draw()
+	return n, err
+}
+
+// SetStatus replaces a section of the status display, nil removes it
+func (c *consoleWriter) SetStatus(section string, lines []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clear()
+	if c.sections == nil {
+		c.sections = make(map[string][]string)
+	}
+	if lines == nil {
+		delete(c.sections, section)
+	} else {
+		c.sections[section] = lines
+	}
+	names := make([]string, 0, len(c.sections))
+	for name := range c.sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	c.status = nil
+	for _, name := range names {
+		c.status = append(c.status, c.sections[name]...)
+	}
+	c.draw()
+}
+
+// clear erases the status lines and leaves the cursor where they started
+func (c *consoleWriter) clear() {
+	if len(c.status) == 0 {
+		return
+	}
+	erase := "\r\033[K" + strings.Repeat("\033[1A\033[K", len(c.status)-1)
+	io.WriteString(c.out, erase)
+}
+
+// draw writes the status lines, cut to the terminal width so none wraps
+// and clear can count them
+func (c *consoleWriter) draw() {
+	if len(c.status) == 0 {
+		return
+	}
+	width := 80
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		width = n
+	}
+	lines := make([]string, len(c.status))
+	for i, line := range c.status {
+		if r := []rune(line); len(r) >= width {
+			line = string(r[:width-1])
+		}
+		lines[i] = line
+	}
+	io.WriteString(c.out, strings.Join(lines, "\n"))
+}
+
+// transferCounter counts the bytes a worker reads for its current object
+// into the worker status, the run's total and the concurrency controller
+func (s *Stats) transferCounter(progress *atomic.Int64) func(int) {
+	return func(n int) {
+		progress.Add(int64(n))
+		s.transferredBytes.Add(int64(n))
+		concurrency.AddBytes(n)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// Limiter kinds
+const (
+	limitGCS = "gcs"
+	limitS3  = "s3"
+)
+
+// Limits are the token buckets shared by every worker in the process:
+// bytes copied per second and API requests per second for each cloud
+type Limits struct {
+	mu    sync.Mutex
+	bytes *rate.Limiter
+	ops   map[string]*rate.Limiter
+}
+
+// limits is process wide so every store and worker draws from the same
+// buckets, whichever way they were created
+var limits = &Limits{
+	bytes: rate.NewLimiter(rate.Inf, 0),
+	ops: map[string]*rate.Limiter{
+		limitGCS: rate.NewLimiter(rate.Inf, 0),
+		limitS3:  rate.NewLimiter(rate.Inf, 0),
+	},
+}
+
+// minByteBurst keeps small limits from splitting reads into tiny chunks
+const minByteBurst = 64 * 1024
+
+// Apply sets the limits from the configuration. It can be called while a
+// migration is running, waiting workers pick up the new rates.
+func (l *Limits) Apply(config *Config) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	setLimit(l.bytes, float64(config.BandwidthLimitBytes), max(int(config.BandwidthLimitBytes), minByteBurst))
+	setLimit(l.ops[limitGCS], config.GCSOpsPerSec, max(int(config.GCSOpsPerSec), 1))
+	setLimit(l.ops[limitS3], config.S3OpsPerSec, max(int(config.S3OpsPerSec), 1))
+}
+
+// setLimit applies a rate, zero or less meaning unlimited
+func setLimit(l *rate.Limiter, perSecond float64, burst int) {
+	if perSecond <= 0 {
+		l.SetLimit(rate.Inf)
+		return
+	}
+	l.SetBurst(burst)
+	l.SetLimit(rate.Limit(perSecond))
+}
+
+// Describe summarises the current limits for logs
+func (l *Limits) Describe() string {
+	describe := func(lim *rate.Limiter, unit string) string {
+		if lim.Limit() == rate.Inf {
+			return "unlimited"
+		}
+		if unit == "B/s" {
+			return formatBytes(int64(lim.Limit())) + "/s"
+		}
+		return fmt.Sprintf("%g %s", float64(lim.Limit()), unit)
+	}
+	return fmt.Sprintf("bandwidth %s, GCS %s, S3 %s",
+		describe(l.bytes, "B/s"), describe(l.ops[limitGCS], "ops/s"), describe(l.ops[limitS3], "ops/s"))
+}
+
+// waitOp blocks until one request to the given cloud may be made
+func (l *Limits) waitOp(ctx context.Context, kind string) error {
+	return l.ops[kind].Wait(ctx)
+}
+
+// Reader throttles r to the shared bandwidth limit
+func (l *Limits) Reader(ctx context.Context, r io.Reader) io.Reader {
+	return &rateReader{ctx: ctx, r: r, lim: l.bytes}
+}
+
+// rateReader takes a token per byte before handing it on
+type rateReader struct {
+	ctx context.Context
+	r   io.Reader
+	lim *rate.Limiter
+}
+
+func (rr *rateReader) Read(p []byte) (int, error) {
+	if rr.lim.Limit() == rate.Inf {
+		return rr.r.Read(p)
+	}
+	// Read no more than one burst at a time
+	if burst := rr.lim.Burst(); burst > 0 && len(p) > burst {
+		p = p[:burst]
+	}
+	n, err := rr.r.Read(p)
+	// The burst can shrink while we read, so wait in chunks of it
+	for remaining := n; remaining > 0; {
+		chunk := remaining
+		if burst := rr.lim.Burst(); burst > 0 && chunk > burst {
+			chunk = burst
+		}
+		if werr := rr.lim.WaitN(rr.ctx, chunk); werr != nil {
+			return n, werr
+		}
+		remaining -= chunk
+	}
+	return n, err
+}
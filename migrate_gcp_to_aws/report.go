@@ -0,0 +1,46 @@
+package main
+
+// runReport summarises the journal: how many objects and bytes are in each
+// state, and optionally which objects failed and why
+func runReport(config *Config, journal *Journal, logger *TimestampLogger, listFailed bool) error {
+	entries := journal.Entries()
+
+	totals := make(map[JournalState]*planTotal)
+	for _, entry := range entries {
+		t := totals[entry.State]
+		if t == nil {
+			t = &planTotal{}
+			totals[entry.State] = t
+		}
+		t.files++
+		t.bytes += entry.Size
+	}
+
+	logger.Log("========================================")
+	logger.Log("            MIGRATION REPORT            ")
+	logger.Log("========================================")
+	logger.Log("")
+	logger.Log("Journal: %s (%d objects)", config.JournalFile, len(entries))
+	logger.Log("")
+	for _, state := range []JournalState{JournalVerified, JournalDeleted, JournalCopied, JournalCopying, JournalQueued, JournalFailed} {
+		t := totals[state]
+		if t == nil {
+			t = &planTotal{}
+		}
+		logger.Log("  %-10s %8d files  %12s", state, t.files, formatBytes(t.bytes))
+	}
+
+	if listFailed && totals[JournalFailed] != nil {
+		logger.Log("")
+		logger.Log("Failed objects:")
+		for _, entry := range entries {
+			if entry.State == JournalFailed {
+				logger.Log("  ✗ %s (generation %d): %s", entry.Name, entry.Generation, entry.Reason)
+			}
+		}
+	}
+
+	logger.Log("")
+	logger.Log("========================================")
+	return nil
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Object outcomes in the result report
+const (
+	resultCopied      = "copied"
+	resultSkipped     = "skipped-exists"
+	resultFailed      = "failed"
+	resultMismatch    = "checksum-mismatch"
+	resultInterrupted = "interrupted"
+)
+
+var resultOutcomes = []string{resultCopied, resultSkipped, resultFailed, resultMismatch, resultInterrupted}
+
+// maxReportFailures bounds the failures listed in the HTML report, the
+// CSV and JSONL have all of them
+const maxReportFailures = 1000
+
+// ObjectResult is what happened to one FileJob
+type ObjectResult struct {
+	Source      string  `json:"source"`
+	Destination string  `json:"destination"`
+	Port        string  `json:"port"`
+	Date        string  `json:"date,omitempty"`
+	Outcome     string  `json:"outcome"`
+	Bytes       int64   `json:"bytes"`
+	Duration    float64 `json:"duration_seconds"`
+	Attempts    int     `json:"attempts,omitempty"`
+	CRC32C      string  `json:"crc32c,omitempty"`
+	// How S3 was sent the object: "single" or "multipart", in Parts parts
+	// of PartSize bytes
+	Upload     string `json:"upload,omitempty"`
+	PartSize   int64  `json:"part_size,omitempty"`
+	Parts      int64  `json:"parts,omitempty"`
+	ErrorClass string `json:"error_class,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+var resultHeader = []string{"source", "destination", "port", "date", "outcome", "bytes", "duration_seconds", "attempts", "crc32c", "upload", "part_size", "parts", "error_class", "error"}
+
+// newObjectResult fills in what every outcome has
+func newObjectResult(job *FileJob, outcome string) ObjectResult {
+	r := ObjectResult{
+		Source:      job.GCSPath,
+		Destination: job.RelativePath,
+		Outcome:     outcome,
+		Bytes:       job.Size,
+	}
+	r.Port, _, _ = strings.Cut(job.GCSPath, "/")
+	if !job.CreatedTime.IsZero() {
+		r.Date = job.CreatedTime.Format("2006-01-02")
+	}
+	return r
+}
+
+// resultTotals counts objects and bytes by outcome
+type resultTotals struct {
+	Files map[string]int
+	Bytes map[string]int64
+}
+
+func (t *resultTotals) add(r ObjectResult) {
+	if t.Files == nil {
+		t.Files = make(map[string]int)
+		t.Bytes = make(map[string]int64)
+	}
+	t.Files[r.Outcome]++
+	t.Bytes[r.Outcome] += r.Bytes
+}
+
+// ResultReport streams every object result to CSV and JSONL files as the
+// run goes, and keeps the breakdowns for the HTML summary written on Close
+type ResultReport struct {
+	mu       sync.Mutex
+	base     string
+	files    []*os.File
+	bufs     []*bufio.Writer
+	csv      *csv.Writer
+	jsonl    *json.Encoder
+	total    resultTotals
+	byPort   map[string]*resultTotals
+	byDate   map[string]*resultTotals
+	failures []ObjectResult
+	dropped  int
+}
+
+// OpenResultReport creates <dir>/migration_<time>.csv and .jsonl, or
+// migration_<time>_<job> for a job; the .html is written when the report
+// is closed
+func OpenResultReport(dir, job string, started time.Time) (*ResultReport, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create report directory: %w", err)
+	}
+	rr := &ResultReport{
+		base:   filepath.Join(dir, "migration_"+jobPath(started.Format("20060102-150405"), job)),
+		byPort: make(map[string]*resultTotals),
+		byDate: make(map[string]*resultTotals),
+	}
+	for _, ext := range []string{".csv", ".jsonl"} {
+		f, err := os.Create(rr.base + ext)
+		if err != nil {
+			rr.closeFiles()
+			return nil, fmt.Errorf("failed to create result report: %w", err)
+		}
+		rr.files = append(rr.files, f)
+		rr.bufs = append(rr.bufs, bufio.NewWriter(f))
+	}
+	rr.csv = csv.NewWriter(rr.bufs[0])
+	rr.jsonl = json.NewEncoder(rr.bufs[1])
+	if err := rr.csv.Write(resultHeader); err != nil {
+		rr.closeFiles()
+		return nil, fmt.Errorf("failed to write result report: %w", err)
+	}
+	return rr, nil
+}
+
+// Paths lists the report files
+func (rr *ResultReport) Paths() []string {
+	return []string{rr.base + ".csv", rr.base + ".jsonl", rr.base + ".html"}
+}
+
+// Record adds one object result. A nil report records nothing.
+func (rr *ResultReport) Record(r ObjectResult) error {
+	if rr == nil {
+		return nil
+	}
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	rr.total.add(r)
+	for _, b := range []struct {
+		m   map[string]*resultTotals
+		key string
+	}{{rr.byPort, r.Port}, {rr.byDate, r.Date}} {
+		t := b.m[b.key]
+		if t == nil {
+			t = &resultTotals{}
+			b.m[b.key] = t
+		}
+		t.add(r)
+	}
+	if r.Outcome == resultFailed || r.Outcome == resultMismatch {
+		if len(rr.failures) < maxReportFailures {
+			rr.failures = append(rr.failures, r)
+		} else {
+			rr.dropped++
+		}
+	}
+
+	if err := rr.csv.Write([]string{
+		r.Source,
+		r.Destination,
+		r.Port,
+		r.Date,
+		r.Outcome,
+		strconv.FormatInt(r.Bytes, 10),
+		strconv.FormatFloat(r.Duration, 'f', 3, 64),
+		strconv.Itoa(r.Attempts),
+		r.CRC32C,
+		r.Upload,
+		optionalInt(r.PartSize),
+		optionalInt(r.Parts),
+		r.ErrorClass,
+		r.Error,
+	}); err != nil {
+		return fmt.Errorf("failed to write result report: %w", err)
+	}
+	if err := rr.jsonl.Encode(r); err != nil {
+		return fmt.Errorf("failed to write result report: %w", err)
+	}
+	return nil
+}
+
+// optionalInt leaves a CSV cell empty for zero
+func optionalInt(n int64) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// recordResult adds r to the run's result report, if there is one
+func (s *Stats) recordResult(r ObjectResult, logger *TimestampLogger) {
+	if err := s.results.Record(r); err != nil {
+		logger.Warn("⚠ Result report write failed", "error", err)
+	}
+}
+
+// ReportSummary describes the run at the top of the HTML report
+type ReportSummary struct {
+	Status      string
+	Source      string
+	Destination string
+	Window      string
+	Started     time.Time
+	Duration    time.Duration
+}
+
+// Close flushes the CSV and JSONL files and writes the HTML summary
+func (rr *ResultReport) Close(summary ReportSummary) error {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	rr.csv.Flush()
+	err := rr.csv.Error()
+	for _, buf := range rr.bufs {
+		if ferr := buf.Flush(); ferr != nil && err == nil {
+			err = ferr
+		}
+	}
+	if cerr := rr.closeFiles(); cerr != nil && err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write result report: %w", err)
+	}
+
+	f, err := os.Create(rr.base + ".html")
+	if err != nil {
+		return fmt.Errorf("failed to create HTML report: %w", err)
+	}
+	if err := resultHTML.Execute(f, rr.htmlData(summary)); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write HTML report: %w", err)
+	}
+	return f.Close()
+}
+
+func (rr *ResultReport) closeFiles() error {
+	var err error
+	for _, f := range rr.files {
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// reportRow is one line of a breakdown table
+type reportRow struct {
+	Name   string
+	Files  []int
+	Bytes  []string
+	Total  int
+	Volume string
+}
+
+func breakdownRows(m map[string]*resultTotals, empty string) []reportRow {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	rows := make([]reportRow, 0, len(names))
+	for _, name := range names {
+		row := totalsRow(name, m[name])
+		if row.Name == "" {
+			row.Name = empty
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func totalsRow(name string, t *resultTotals) reportRow {
+	row := reportRow{Name: name}
+	var bytes int64
+	for _, outcome := range resultOutcomes {
+		row.Files = append(row.Files, t.Files[outcome])
+		row.Bytes = append(row.Bytes, formatBytes(t.Bytes[outcome]))
+		row.Total += t.Files[outcome]
+		bytes += t.Bytes[outcome]
+	}
+	row.Volume = formatBytes(bytes)
+	return row
+}
+
+func (rr *ResultReport) htmlData(summary ReportSummary) any {
+	return struct {
+		ReportSummary
+		Generated time.Time
+		Outcomes  []string
+		Total     reportRow
+		ByPort    []reportRow
+		ByDate    []reportRow
+		Failures  []ObjectResult
+		Dropped   int
+		Files     []string
+	}{
+		ReportSummary: summary,
+		Generated:     time.Now(),
+		Outcomes:      resultOutcomes,
+		Total:         totalsRow("Total", &rr.total),
+		ByPort:        breakdownRows(rr.byPort, "(root)"),
+		ByDate:        breakdownRows(rr.byDate, "(no date)"),
+		Failures:      rr.failures,
+		Dropped:       rr.dropped,
+		Files:         []string{filepath.Base(rr.base + ".csv"), filepath.Base(rr.base + ".jsonl")},
+	}
+}
+
+var resultHTML = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Migration report {{.Started.Format "2006-01-02 15:04:05"}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.5em; } h2 { font-size: 1.2em; margin-top: 2em; }
+table { border-collapse: collapse; margin-top: 0.5em; }
+th, td { border: 1px solid #ccc; padding: 4px 10px; text-align: right; }
+th { background: #f3f3f3; } td:first-child, th:first-child { text-align: left; }
+.failed { color: #b00020; } .ok { color: #1b7f3a; }
+dl { display: grid; grid-template-columns: max-content auto; gap: 2px 16px; }
+dt { font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>Migration report</h1>
+<dl>
+<dt>Status</dt><dd>{{.Status}}</dd>
+<dt>Source</dt><dd>{{.Source}}</dd>
+<dt>Destination</dt><dd>{{.Destination}}</dd>
+<dt>Date window</dt><dd>{{.Window}}</dd>
+<dt>Started</dt><dd>{{.Started.Format "2006-01-02 15:04:05 MST"}}</dd>
+<dt>Duration</dt><dd>{{.Duration}}</dd>
+<dt>Per-object results</dt><dd>{{range $i, $f := .Files}}{{if $i}}, {{end}}{{$f}}{{end}}</dd>
+</dl>
+<h2>Totals</h2>
+<table>
+<tr><th>Outcome</th><th>objects</th><th>bytes</th></tr>
+{{range $i, $o := .Outcomes}}<tr><td{{if or (eq $o "failed") (eq $o "checksum-mismatch")}} class="failed"{{else if eq $o "copied"}} class="ok"{{end}}>{{$o}}</td><td>{{index $.Total.Files $i}}</td><td>{{index $.Total.Bytes $i}}</td></tr>
+{{end}}<tr><th>Total</th><th>{{.Total.Total}}</th><th>{{.Total.Volume}}</th></tr>
+</table>
+<h2>By port</h2>
+<table>
+<tr><th>Port</th>{{range .Outcomes}}<th>{{.}}</th>{{end}}<th>objects</th><th>bytes</th></tr>
+{{range .ByPort}}<tr><td>{{.Name}}</td>{{range $i, $n := .Files}}<td>{{$n}}</td>{{end}}<td>{{.Total}}</td><td>{{.Volume}}</td></tr>
+{{end}}</table>
+<h2>By date folder</h2>
+<table>
+<tr><th>Date</th>{{range .Outcomes}}<th>{{.}}</th>{{end}}<th>objects</th><th>bytes</th></tr>
+{{range .ByDate}}<tr><td>{{.Name}}</td>{{range $i, $n := .Files}}<td>{{$n}}</td>{{end}}<td>{{.Total}}</td><td>{{.Volume}}</td></tr>
+{{end}}</table>
+{{if .Failures}}<h2 class="failed">Failures</h2>
+<table>
+<tr><th>Source</th><th>Destination</th><th>Outcome</th><th>Attempts</th><th>Class</th><th>Error</th></tr>
+{{range .Failures}}<tr><td>{{.Source}}</td><td style="text-align:left">{{.Destination}}</td><td>{{.Outcome}}</td><td>{{.Attempts}}</td><td>{{.ErrorClass}}</td><td style="text-align:left">{{.Error}}</td></tr>
+{{end}}</table>
+{{if .Dropped}}<p>{{.Dropped}} more failures are in the CSV and JSONL files.</p>{{end}}{{end}}
+<p style="color:#777">Generated {{.Generated.Format "2006-01-02 15:04:05 MST"}}</p>
+</body>
+</html>
+`))
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"google.golang.org/api/googleapi"
+)
+
+// errorClass groups errors by how the worker should react to them
+type errorClass string
+
+const (
+	classThrottled errorClass = "throttled"
+	classServer    errorClass = "server"
+	classNetwork   errorClass = "network"
+	classTimeout   errorClass = "timeout"
+	classChecksum  errorClass = "checksum"
+	classCanceled  errorClass = "canceled"
+	classPermanent errorClass = "permanent"
+	classUnknown   errorClass = "unknown"
+)
+
+// Retryable reports whether another attempt could succeed
+func (c errorClass) Retryable() bool {
+	switch c {
+	case classCanceled, classPermanent:
+		return false
+	}
+	return true
+}
+
+// S3 error codes whose class cannot be told from the HTTP status alone
+var s3CodeClasses = map[string]errorClass{
+	"SlowDown":                    classThrottled,
+	"Throttling":                  classThrottled,
+	"ThrottlingException":         classThrottled,
+	"RequestLimitExceeded":        classThrottled,
+	"TooManyRequests":             classThrottled,
+	"RequestTimeout":              classTimeout,
+	"BadDigest":                   classChecksum,
+	"XAmzContentChecksumMismatch": classChecksum,
+	"AccessDenied":                classPermanent,
+	"AllAccessDisabled":           classPermanent,
+	"InvalidAccessKeyId":          classPermanent,
+	"SignatureDoesNotMatch":       classPermanent,
+	"NoSuchBucket":                classPermanent,
+	"NoSuchKey":                   classPermanent,
+	"InvalidBucketName":           classPermanent,
+	"KeyTooLongError":             classPermanent,
+	"EntityTooLarge":              classPermanent,
+}
+
+// classifyError decides whether an error from GCS or S3 is worth retrying
+func classifyError(err error) errorClass {
+	if err == nil {
+		return ""
+	}
+	if errors.Is(err, context.Canceled) {
+		return classCanceled
+	}
+	if errors.Is(err, errChecksumMismatch) {
+		return classChecksum
+	}
+	if errors.Is(err, errObjectNotFound) || errors.Is(err, storage.ErrObjectNotExist) || errors.Is(err, storage.ErrBucketNotExist) {
+		return classPermanent
+	}
+
+	// The AWS SDK keeps the underlying cause in OrigErr instead of Unwrap,
+	// so walk that chain by hand
+	cause := err
+	for {
+		var awsErr awserr.Error
+		if !errors.As(cause, &awsErr) {
+			break
+		}
+		if class, ok := s3CodeClasses[awsErr.Code()]; ok {
+			return class
+		}
+		var reqErr awserr.RequestFailure
+		if errors.As(cause, &reqErr) {
+			if class := classifyStatus(reqErr.StatusCode()); class != classUnknown {
+				return class
+			}
+		}
+		if awsErr.OrigErr() == nil {
+			break
+		}
+		cause = awsErr.OrigErr()
+	}
+
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		if class := classifyStatus(apiErr.Code); class != classUnknown {
+			return class
+		}
+	}
+
+	return classifyCause(cause)
+}
+
+// classifyStatus maps an HTTP status code to an error class
+func classifyStatus(code int) errorClass {
+	switch {
+	case code == http.StatusTooManyRequests:
+		return classThrottled
+	case code == http.StatusRequestTimeout:
+		return classTimeout
+	case code >= 500:
+		return classServer
+	case code >= 400:
+		return classPermanent
+	}
+	return classUnknown
+}
+
+// classifyCause recognises transport level failures
+func classifyCause(err error) errorClass {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return classTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return classTimeout
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return classNetwork
+	}
+	msg := err.Error()
+	if strings.Contains(msg, "connection reset") || strings.Contains(msg, "broken pipe") ||
+		strings.Contains(msg, "unexpected EOF") {
+		return classNetwork
+	}
+	return classUnknown
+}
+
+// RetryPolicy controls how often and how patiently a copy is retried
+type RetryPolicy struct {
+	MaxAttempts    int
+	BaseDelay      time.Duration
+	MaxDelay       time.Duration
+	AttemptTimeout time.Duration
+}
+
+// backoff returns the delay before the given attempt, using exponential
+// growth with full jitter so workers do not retry in lockstep
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay << (attempt - 1)
+	if delay <= 0 || delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(delay)) + 1)
+}
+
+// Do runs op until it succeeds, fails permanently or runs out of attempts.
+// onRetry is called before sleeping between attempts. It returns the number
+// of attempts made and the last error.
+func (p RetryPolicy) Do(
+	ctx context.Context,
+	op func(ctx context.Context) error,
+	onRetry func(attempt int, err error, class errorClass, delay time.Duration),
+) (int, error) {
+	maxAttempts := p.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if p.AttemptTimeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, p.AttemptTimeout)
+		}
+		err = op(attemptCtx)
+		cancel()
+		if err == nil {
+			return attempt, nil
+		}
+
+		// A cancelled run is not the object's fault, stop right away
+		if ctx.Err() != nil {
+			return attempt, err
+		}
+		class := classifyError(err)
+		if !class.Retryable() || attempt >= maxAttempts {
+			return attempt, err
+		}
+
+		delay := p.backoff(attempt)
+		if onRetry != nil {
+			onRetry(attempt, err, class, delay)
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return attempt, err
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// errInterrupted is returned by commands stopped by a signal
+var errInterrupted = errors.New("interrupted")
+
+// Shutdown tracks a two stage stop. The first SIGINT or SIGTERM stops new
+// work and gives in-flight copies the grace period to finish; a second
+// signal or the end of the grace period cancels them.
+type Shutdown struct {
+	once sync.Once
+	stop chan struct{}
+}
+
+// shutdown is process wide, like the signals it follows
+var shutdown = &Shutdown{stop: make(chan struct{})}
+
+// Stopping is closed once a stop was requested
+func (s *Shutdown) Stopping() <-chan struct{} {
+	return s.stop
+}
+
+// Requested reports whether a stop was requested
+func (s *Shutdown) Requested() bool {
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Shutdown) request() {
+	s.once.Do(func() { close(s.stop) })
+}
+
+// handleSignals returns a context that is cancelled when in-flight work
+// has to be abandoned, and a function to stop listening for signals
+func handleSignals(grace time.Duration, logger *TimestampLogger) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-signals:
+			logger.Log("")
+			logger.Warn("⚠ Stopping: starting no new objects, in-flight copies have the grace period to finish (send the signal again to abort now)",
+				"signal", sig.String(), "grace", grace)
+			shutdown.request()
+		case <-done:
+			return
+		}
+
+		timer := time.NewTimer(grace)
+		defer timer.Stop()
+		select {
+		case sig := <-signals:
+			logger.Warn("⚠ Signal received again, aborting in-flight copies", "signal", sig.String())
+		case <-timer.C:
+			logger.Warn("⚠ Grace period over, aborting in-flight copies")
+		case <-done:
+			return
+		}
+		cancel()
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		close(done)
+		cancel()
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// errObjectNotFound is returned (wrapped) by every store for missing objects
+var errObjectNotFound = errors.New("object not found")
+
+// errGenerationMismatch is returned (wrapped) when a generation
+// precondition fails
+var errGenerationMismatch = errors.New("object has changed")
+
+// ObjectInfo describes a stored object, whatever the backend
+type ObjectInfo struct {
+	Key  string
+	Size int64
+	// Generation is the GCS object generation. Stores without generations
+	// use the modification time in nanoseconds, so a rewritten object still
+	// gets a new journal key.
+	Generation int64
+	// Digests the backend knows without reading the object. MD5 is empty
+	// and HasCRC32C false when the backend has none.
+	MD5       []byte
+	CRC32C    uint32
+	HasCRC32C bool
+
+	ObjectHeaders
+	StorageClass string
+	// Metadata is the user metadata stored with the object
+	Metadata map[string]string
+	Created  time.Time
+	Updated  time.Time
+}
+
+// PutOptions carries what a store needs to write and check an object
+type PutOptions struct {
+	// Source describes the object being copied. Stores use its size and
+	// digests to let the backend reject a corrupted upload.
+	Source *ObjectInfo
+	// Headers and Metadata are written with the object where the backend
+	// can store them
+	Headers  ObjectHeaders
+	Metadata map[string]string
+	// Destination options are applied by S3 and ignored elsewhere, nil
+	// keeps the bucket defaults
+	Destination *DestinationOptions
+}
+
+// PutResult is what a store saw while writing an object
+type PutResult struct {
+	Digest Digest
+	// Upload is how S3 was sent the object, zero for other stores
+	Upload UploadPlan
+}
+
+// ObjectStore is one side of a migration. The engine only talks to stores,
+// so any source can be copied to any destination.
+type ObjectStore interface {
+	// URI identifies the store in logs, e.g. gs://bucket
+	URI() string
+	// List calls fn for every object under prefix
+	List(ctx context.Context, prefix string, fn func(*ObjectInfo) error) error
+	// ListLevel lists one level under prefix, a "/" delimited query: fn
+	// gets the objects directly under it and the sub-prefixes are returned
+	ListLevel(ctx context.Context, prefix string, fn func(*ObjectInfo) error) ([]string, error)
+	// Stat returns an object's attributes, or errObjectNotFound
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	// Open reads an object. A non-zero generation pins the read to that
+	// generation: GCS reads it even if it is no longer live, the other
+	// stores fail with errGenerationMismatch if the object changed.
+	Open(ctx context.Context, key string, generation int64) (io.ReadCloser, *ObjectInfo, error)
+	// Put writes an object and returns the digests of the bytes written
+	Put(ctx context.Context, key string, r io.Reader, opts PutOptions) (*PutResult, error)
+	// Delete removes an object. A non-zero generation is a precondition,
+	// the delete fails with errGenerationMismatch if the object changed.
+	Delete(ctx context.Context, key string, generation int64) error
+	Close() error
+}
+
+// newStore opens a store from a URI: gs://bucket, s3://bucket, or a local
+// directory given as file:///path or a plain path
+func newStore(ctx context.Context, uri string, config *Config) (ObjectStore, error) {
+	switch {
+	case strings.HasPrefix(uri, "gs://"):
+		return newGCSStore(ctx, bucketFromURI(uri, "gs://"), config)
+	case strings.HasPrefix(uri, "s3://"):
+		return newS3Store(bucketFromURI(uri, "s3://"), config)
+	case strings.HasPrefix(uri, "file://"):
+		return newLocalStore(strings.TrimPrefix(uri, "file://"))
+	case strings.Contains(uri, "://"):
+		return nil, fmt.Errorf("unsupported store %q", uri)
+	default:
+		return newLocalStore(uri)
+	}
+}
+
+// endpointNote names the custom endpoint a store URI is reached at, if any
+func (c *Config) endpointNote(uri string) string {
+	switch {
+	case strings.HasPrefix(uri, "s3://") && c.S3Endpoint != "":
+		return " at " + c.S3Endpoint
+	case strings.HasPrefix(uri, "gs://") && c.GCSEndpoint != "":
+		return " at " + c.GCSEndpoint
+	}
+	return ""
+}
+
+func bucketFromURI(uri, scheme string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(uri, scheme), "/")
+}
+
+// openStores opens the source and destination of a migration
+func openStores(ctx context.Context, config *Config, logger *TimestampLogger) (ObjectStore, ObjectStore, error) {
+	srcURI, dstURI, err := config.StoreURIs()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	logger.Log("Opening source %s%s...", srcURI, config.endpointNote(srcURI))
+	src, err := newStore(ctx, srcURI, config)
+	if err != nil {
+		if strings.HasPrefix(srcURI, "gs://") && !config.GCSNoAuth {
+			logger.Log("Please run: gcloud auth application-default login")
+		}
+		return nil, nil, fmt.Errorf("failed to open source: %w", err)
+	}
+
+	logger.Log("Opening destination %s%s...", dstURI, config.endpointNote(dstURI))
+	dst, err := newStore(ctx, dstURI, config)
+	if err != nil {
+		src.Close()
+		return nil, nil, fmt.Errorf("failed to open destination: %w", err)
+	}
+
+	return src, dst, nil
+}
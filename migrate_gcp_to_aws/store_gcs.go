@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+// gcsStore is a Google Cloud Storage bucket
+type gcsStore struct {
+	client *storage.Client
+	bucket string
+}
+
+func newGCSStore(ctx context.Context, bucket string, config *Config) (*gcsStore, error) {
+	// A custom endpoint is the JSON API root, e.g.
+	// http://localhost:4443/storage/v1/ for fake-gcs-server
+	var opts []option.ClientOption
+	if config.GCSEndpoint != "" {
+		opts = append(opts, option.WithEndpoint(config.GCSEndpoint))
+	}
+	if config.GCSNoAuth {
+		opts = append(opts, option.WithoutAuthentication())
+	}
+	client, err := storage.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCS client: %w", err)
+	}
+	return &gcsStore{client: client, bucket: bucket}, nil
+}
+
+func (g *gcsStore) URI() string {
+	return "gs://" + g.bucket
+}
+
+func gcsObjectInfo(attrs *storage.ObjectAttrs) *ObjectInfo {
+	return &ObjectInfo{
+		Key:        attrs.Name,
+		Size:       attrs.Size,
+		Generation: attrs.Generation,
+		MD5:        attrs.MD5,
+		CRC32C:     attrs.CRC32C,
+		HasCRC32C:  true,
+		ObjectHeaders: ObjectHeaders{
+			ContentType:        attrs.ContentType,
+			ContentDisposition: attrs.ContentDisposition,
+			ContentEncoding:    attrs.ContentEncoding,
+			ContentLanguage:    attrs.ContentLanguage,
+			CacheControl:       attrs.CacheControl,
+		},
+		StorageClass: attrs.StorageClass,
+		Metadata:     attrs.Metadata,
+		Created:      attrs.Created,
+		Updated:      attrs.Updated,
+	}
+}
+
+// gcsError maps GCS not-found and precondition errors onto
+// errObjectNotFound and errGenerationMismatch
+func gcsError(err error) error {
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return fmt.Errorf("%w: %v", errObjectNotFound, err)
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed {
+		return fmt.Errorf("%w: %v", errGenerationMismatch, err)
+	}
+	return err
+}
+
+func (g *gcsStore) List(ctx context.Context, prefix string, fn func(*ObjectInfo) error) error {
+	it := g.client.Bucket(g.bucket).Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		// Next fetches a page once the buffered ones are used up
+		if it.PageInfo().Remaining() == 0 {
+			if err := limits.waitOp(ctx, limitGCS); err != nil {
+				return err
+			}
+		}
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// Skip directory placeholders
+		if strings.HasSuffix(attrs.Name, "/") {
+			continue
+		}
+		if err := fn(gcsObjectInfo(attrs)); err != nil {
+			return err
+		}
+	}
+}
+
+func (g *gcsStore) ListLevel(ctx context.Context, prefix string, fn func(*ObjectInfo) error) ([]string, error) {
+	it := g.client.Bucket(g.bucket).Objects(ctx, &storage.Query{Prefix: prefix, Delimiter: "/"})
+	var prefixes []string
+	for {
+		if it.PageInfo().Remaining() == 0 {
+			if err := limits.waitOp(ctx, limitGCS); err != nil {
+				return nil, err
+			}
+		}
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return prefixes, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if attrs.Prefix != "" {
+			prefixes = append(prefixes, attrs.Prefix)
+			continue
+		}
+		if strings.HasSuffix(attrs.Name, "/") {
+			continue
+		}
+		if err := fn(gcsObjectInfo(attrs)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (g *gcsStore) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	if err := limits.waitOp(ctx, limitGCS); err != nil {
+		return nil, err
+	}
+	attrs, err := g.client.Bucket(g.bucket).Object(key).Attrs(ctx)
+	if err != nil {
+		return nil, gcsError(err)
+	}
+	return gcsObjectInfo(attrs), nil
+}
+
+// Open reads the attributes first and pins the generation, so the bytes
+// streamed are exactly the ones the checksums describe. Compressed objects
+// are read as stored, otherwise decompressive transcoding would change the
+// bytes under the checksum.
+func (g *gcsStore) Open(ctx context.Context, key string, generation int64) (io.ReadCloser, *ObjectInfo, error) {
+	obj := g.client.Bucket(g.bucket).Object(key)
+	if generation != 0 {
+		obj = obj.Generation(generation)
+	}
+	if err := limits.waitOp(ctx, limitGCS); err != nil {
+		return nil, nil, err
+	}
+	attrs, err := obj.Attrs(ctx)
+	if err != nil {
+		return nil, nil, gcsError(err)
+	}
+	if err := limits.waitOp(ctx, limitGCS); err != nil {
+		return nil, nil, err
+	}
+	reader, err := obj.Generation(attrs.Generation).ReadCompressed(true).NewReader(ctx)
+	if err != nil {
+		return nil, nil, gcsError(err)
+	}
+	return reader, gcsObjectInfo(attrs), nil
+}
+
+// Put uploads an object. With the source digests attached GCS rejects the
+// upload itself if the bytes it received do not match.
+func (g *gcsStore) Put(ctx context.Context, key string, r io.Reader, opts PutOptions) (*PutResult, error) {
+	if err := limits.waitOp(ctx, limitGCS); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w := g.client.Bucket(g.bucket).Object(key).NewWriter(ctx)
+	w.ContentType = opts.Headers.ContentType
+	w.ContentDisposition = opts.Headers.ContentDisposition
+	w.ContentEncoding = opts.Headers.ContentEncoding
+	w.ContentLanguage = opts.Headers.ContentLanguage
+	w.CacheControl = opts.Headers.CacheControl
+	w.Metadata = opts.Metadata
+	if src := opts.Source; src != nil {
+		if src.HasCRC32C {
+			w.CRC32C = src.CRC32C
+			w.SendCRC32C = true
+		}
+		if len(src.MD5) > 0 {
+			w.MD5 = src.MD5
+		}
+	}
+
+	body := newChecksumReader(r)
+	if _, err := io.Copy(w, body); err != nil {
+		// Cancelling before Close abandons the upload
+		cancel()
+		w.Close()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return &PutResult{Digest: body.Digest()}, nil
+}
+
+func (g *gcsStore) Delete(ctx context.Context, key string, generation int64) error {
+	obj := g.client.Bucket(g.bucket).Object(key)
+	if generation != 0 {
+		obj = obj.If(storage.Conditions{GenerationMatch: generation})
+	}
+	if err := limits.waitOp(ctx, limitGCS); err != nil {
+		return err
+	}
+	return gcsError(obj.Delete(ctx))
+}
+
+func (g *gcsStore) Close() error {
+	return g.client.Close()
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// localStore is a directory tree on the local filesystem. Keys are slash
+// separated paths relative to the root.
+type localStore struct {
+	root string
+}
+
+func newLocalStore(root string) (*localStore, error) {
+	if root == "" {
+		return nil, errors.New("local store needs a directory")
+	}
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(abs, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", abs, err)
+	}
+	return &localStore{root: abs}, nil
+}
+
+func (l *localStore) URI() string {
+	return "file://" + l.root
+}
+
+// path turns a key into a file path, refusing keys that escape the root
+func (l *localStore) path(key string) (string, error) {
+	p := filepath.Join(l.root, filepath.FromSlash(key))
+	if p != l.root && !strings.HasPrefix(p, l.root+string(filepath.Separator)) {
+		return "", fmt.Errorf("key %q is outside %s", key, l.root)
+	}
+	return p, nil
+}
+
+// localError maps a missing file onto errObjectNotFound
+func localError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %v", errObjectNotFound, err)
+	}
+	return err
+}
+
+func localObjectInfo(key string, fi fs.FileInfo) *ObjectInfo {
+	return &ObjectInfo{
+		Key:        key,
+		Size:       fi.Size(),
+		Generation: fi.ModTime().UnixNano(),
+		Updated:    fi.ModTime(),
+	}
+}
+
+// List walks the directory the prefix is in, not the whole tree
+func (l *localStore) List(ctx context.Context, prefix string, fn func(*ObjectInfo) error) error {
+	start, err := l.path(prefixDir(prefix))
+	if err != nil {
+		return err
+	}
+	err = filepath.WalkDir(start, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(d.Name(), ".partial") {
+			return nil
+		}
+		rel, err := filepath.Rel(l.root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return fn(localObjectInfo(key, fi))
+	})
+	if errors.Is(err, fs.ErrNotExist) && start != l.root {
+		return nil
+	}
+	return err
+}
+
+func (l *localStore) ListLevel(ctx context.Context, prefix string, fn func(*ObjectInfo) error) ([]string, error) {
+	dir := prefixDir(prefix)
+	p, err := l.path(dir)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var prefixes []string
+	for _, d := range entries {
+		key := dir + d.Name()
+		if !strings.HasPrefix(key, prefix) || strings.HasSuffix(key, ".partial") {
+			continue
+		}
+		if d.IsDir() {
+			prefixes = append(prefixes, key+"/")
+			continue
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return nil, err
+		}
+		if err := fn(localObjectInfo(key, fi)); err != nil {
+			return nil, err
+		}
+	}
+	return prefixes, nil
+}
+
+// prefixDir is the part of a prefix up to its last slash, e.g. "cam1/2025"
+// is in "cam1/"
+func prefixDir(prefix string) string {
+	return prefix[:strings.LastIndex(prefix, "/")+1]
+}
+
+func (l *localStore) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(p)
+	if err != nil {
+		return nil, localError(err)
+	}
+	if fi.IsDir() {
+		return nil, fmt.Errorf("%w: %s is a directory", errObjectNotFound, p)
+	}
+	return localObjectInfo(key, fi), nil
+}
+
+func (l *localStore) Open(ctx context.Context, key string, generation int64) (io.ReadCloser, *ObjectInfo, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, nil, localError(err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	info := localObjectInfo(key, fi)
+	if generation != 0 && info.Generation != generation {
+		f.Close()
+		return nil, nil, fmt.Errorf("%w: %s was modified at %s", errGenerationMismatch, key, info.Updated)
+	}
+	return f, info, nil
+}
+
+// Put writes to a .partial file next to the target and renames it into
+// place once everything is on disk, so a killed run never leaves a short
+// file under the real name. Headers and metadata are not kept on disk.
+func (l *localStore) Put(ctx context.Context, key string, r io.Reader, opts PutOptions) (*PutResult, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return nil, err
+	}
+
+	tmp := p + ".partial"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp)
+
+	body := newChecksumReader(r)
+	if _, err := io.Copy(f, &contextReader{ctx: ctx, r: body}); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, p); err != nil {
+		return nil, err
+	}
+	return &PutResult{Digest: body.Digest()}, nil
+}
+
+func (l *localStore) Delete(ctx context.Context, key string, generation int64) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if generation != 0 {
+		info, err := l.Stat(ctx, key)
+		if err != nil {
+			return err
+		}
+		if info.Generation != generation {
+			return fmt.Errorf("%w: %s was modified at %s", errGenerationMismatch, p, info.Updated)
+		}
+	}
+	return localError(os.Remove(p))
+}
+
+func (l *localStore) Close() error {
+	return nil
+}
+
+// contextReader stops a copy once its context is cancelled, for writers
+// that do not take a context themselves
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"errors"
+	"hash/crc32"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestLocalStoreRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	store, err := newLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := "recorded at the gate"
+	result, err := store.Put(ctx, "port1/2025-09-10/a.mp4", strings.NewReader(content), PutOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := md5.Sum([]byte(content))
+	crc := crc32.Checksum([]byte(content), crc32.MakeTable(crc32.Castagnoli))
+	if d := result.Digest; d.Size != int64(len(content)) || !bytes.Equal(d.MD5, sum[:]) || d.CRC32C != crc {
+		t.Errorf("put digest %+v, want size %d, md5 %x, crc32c %08x", d, len(content), sum, crc)
+	}
+	if _, err := store.Put(ctx, "port2/b.json", strings.NewReader("{}"), PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	err = store.List(ctx, "port1/", func(info *ObjectInfo) error {
+		keys = append(keys, info.Key)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(keys)
+	if len(keys) != 1 || keys[0] != "port1/2025-09-10/a.mp4" {
+		t.Errorf("listed %v under port1/", keys)
+	}
+
+	r, info, err := store.Open(ctx, "port1/2025-09-10/a.mp4", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || !bytes.Equal(data, []byte(content)) {
+		t.Errorf("read %q, %v, want %q", data, err, content)
+	}
+	if info.Size != int64(len(content)) || info.Generation == 0 {
+		t.Errorf("open info %+v, want size %d and a generation", info, len(content))
+	}
+
+	if r, _, err := store.Open(ctx, "port1/2025-09-10/a.mp4", info.Generation+1); !errors.Is(err, errGenerationMismatch) {
+		if err == nil {
+			r.Close()
+		}
+		t.Errorf("open of another generation: %v, want errGenerationMismatch", err)
+	}
+	if err := store.Delete(ctx, "port1/2025-09-10/a.mp4", info.Generation+1); !errors.Is(err, errGenerationMismatch) {
+		t.Errorf("delete of another generation: %v, want errGenerationMismatch", err)
+	}
+	if err := store.Delete(ctx, "port1/2025-09-10/a.mp4", info.Generation); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Stat(ctx, "port1/2025-09-10/a.mp4"); !errors.Is(err, errObjectNotFound) {
+		t.Errorf("stat after delete: %v, want errObjectNotFound", err)
+	}
+	if err := store.Delete(ctx, "port1/2025-09-10/a.mp4", 0); !errors.Is(err, errObjectNotFound) {
+		t.Errorf("second delete: %v, want errObjectNotFound", err)
+	}
+}
+
+func TestLocalStoreRefusesKeysOutsideRoot(t *testing.T) {
+	store, err := newLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Put(context.Background(), "../escape.mp4", strings.NewReader("x"), PutOptions{}); err == nil {
+		t.Error("key outside the root was written")
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// s3Store is an Amazon S3 bucket
+type s3Store struct {
+	client   *s3.S3
+	uploader *s3manager.Uploader
+	sizing   PartSizing
+	bucket   string
+}
+
+func newS3Store(bucket string, config *Config) (*s3Store, error) {
+	// Without a credentials file the default chain (environment, shared
+	// config, instance role) is used
+	awsConfig := aws.Config{Region: aws.String(config.AWSRegion)}
+	if config.AWSCredentialsFile != "" {
+		awsConfig.Credentials = credentials.NewSharedCredentials(config.AWSCredentialsFile, config.AWSProfile)
+	}
+	if config.S3Endpoint != "" {
+		awsConfig.Endpoint = aws.String(config.S3Endpoint)
+		// Requests are still signed for a region, which S3-compatible
+		// stores mostly ignore
+		if config.AWSRegion == "" {
+			awsConfig.Region = aws.String("us-east-1")
+		}
+	}
+	awsConfig.S3ForcePathStyle = aws.Bool(config.S3ForcePathStyle)
+	if config.S3InsecureSkipVerify {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		awsConfig.HTTPClient = &http.Client{Transport: transport}
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            awsConfig,
+		Profile:           config.AWSProfile,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AWS session: %w", err)
+	}
+
+	// Every request, including each part and list page, takes a token
+	// from the shared S3 bucket before it is signed and sent
+	sess.Handlers.Sign.PushFront(func(r *request.Request) {
+		if err := limits.waitOp(r.Context(), limitS3); err != nil {
+			r.Error = err
+		}
+	})
+
+	// Part size is set for each object in Put
+	uploader := s3manager.NewUploader(sess, func(u *s3manager.Uploader) {
+		u.Concurrency = defaultPartConcurrency
+		u.MaxUploadParts = s3MaxParts
+		u.LeavePartsOnError = false // Clean up failed uploads
+	})
+
+	return &s3Store{client: s3.New(sess), uploader: uploader, sizing: config.PartSizing(), bucket: bucket}, nil
+}
+
+func (s *s3Store) URI() string {
+	return "s3://" + s.bucket
+}
+
+// s3Error maps S3 not-found errors onto errObjectNotFound
+func s3Error(err error) error {
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) && reqErr.StatusCode() == http.StatusNotFound {
+		return fmt.Errorf("%w: %v", errObjectNotFound, err)
+	}
+	return err
+}
+
+// etagMD5 trusts an ETag as the MD5 of the object only when the object is
+// not encrypted with KMS or a customer key, which change the ETag
+func etagMD5(etag, sse, sseCustomerAlgorithm *string) []byte {
+	if sseCustomerAlgorithm != nil || strings.HasPrefix(aws.StringValue(sse), "aws:kms") {
+		return nil
+	}
+	return md5FromETag(aws.StringValue(etag))
+}
+
+// s3Metadata turns the user metadata of a response into plain strings.
+// The SDK capitalises the keys, stores use them lower-cased.
+func s3Metadata(meta map[string]*string) map[string]string {
+	if len(meta) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(meta))
+	for key, value := range meta {
+		out[strings.ToLower(key)] = aws.StringValue(value)
+	}
+	return out
+}
+
+// s3StorageClass fills in STANDARD, which HEAD and GET leave out
+func s3StorageClass(class *string) string {
+	if class == nil {
+		return s3.StorageClassStandard
+	}
+	return *class
+}
+
+// setString sets an optional request field, leaving it nil when empty
+func setString(field **string, value string) {
+	if value != "" {
+		*field = aws.String(value)
+	}
+}
+
+func (s *s3Store) List(ctx context.Context, prefix string, fn func(*ObjectInfo) error) error {
+	var fnErr error
+	err := s.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, obj := range page.Contents {
+			if strings.HasSuffix(aws.StringValue(obj.Key), "/") {
+				continue
+			}
+			if fnErr = fn(s3ListedInfo(obj)); fnErr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	return err
+}
+
+func (s *s3Store) ListLevel(ctx context.Context, prefix string, fn func(*ObjectInfo) error) ([]string, error) {
+	var prefixes []string
+	var fnErr error
+	err := s.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(s.bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	}, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, p := range page.CommonPrefixes {
+			prefixes = append(prefixes, aws.StringValue(p.Prefix))
+		}
+		for _, obj := range page.Contents {
+			if strings.HasSuffix(aws.StringValue(obj.Key), "/") {
+				continue
+			}
+			if fnErr = fn(s3ListedInfo(obj)); fnErr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if fnErr != nil {
+		return nil, fnErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return prefixes, nil
+}
+
+// s3ListedInfo describes an object from a listing. S3 has no generations,
+// the modification time stands in. The listing does not say how an object
+// is encrypted, so the ETag is not used as an MD5 here.
+func s3ListedInfo(obj *s3.Object) *ObjectInfo {
+	info := &ObjectInfo{
+		Key:          aws.StringValue(obj.Key),
+		Size:         aws.Int64Value(obj.Size),
+		StorageClass: s3StorageClass(obj.StorageClass),
+		Updated:      aws.TimeValue(obj.LastModified),
+	}
+	info.Generation = info.Updated.UnixNano()
+	return info
+}
+
+func (s *s3Store) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	head, err := s.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket:       aws.String(s.bucket),
+		Key:          aws.String(key),
+		ChecksumMode: aws.String(s3.ChecksumModeEnabled),
+	})
+	if err != nil {
+		return nil, s3Error(err)
+	}
+	info := &ObjectInfo{
+		Key:  key,
+		Size: aws.Int64Value(head.ContentLength),
+		MD5:  etagMD5(head.ETag, head.ServerSideEncryption, head.SSECustomerAlgorithm),
+		ObjectHeaders: ObjectHeaders{
+			ContentType:        aws.StringValue(head.ContentType),
+			ContentDisposition: aws.StringValue(head.ContentDisposition),
+			ContentEncoding:    aws.StringValue(head.ContentEncoding),
+			ContentLanguage:    aws.StringValue(head.ContentLanguage),
+			CacheControl:       aws.StringValue(head.CacheControl),
+		},
+		StorageClass: s3StorageClass(head.StorageClass),
+		Metadata:     s3Metadata(head.Metadata),
+		Updated:      aws.TimeValue(head.LastModified),
+	}
+	info.Generation = info.Updated.UnixNano()
+	info.CRC32C, info.HasCRC32C = parseCRC32C(aws.StringValue(head.ChecksumCRC32C))
+	return info, nil
+}
+
+func (s *s3Store) Open(ctx context.Context, key string, generation int64) (io.ReadCloser, *ObjectInfo, error) {
+	obj, err := s.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket:       aws.String(s.bucket),
+		Key:          aws.String(key),
+		ChecksumMode: aws.String(s3.ChecksumModeEnabled),
+	})
+	if err != nil {
+		return nil, nil, s3Error(err)
+	}
+	info := &ObjectInfo{
+		Key:  key,
+		Size: aws.Int64Value(obj.ContentLength),
+		MD5:  etagMD5(obj.ETag, obj.ServerSideEncryption, obj.SSECustomerAlgorithm),
+		ObjectHeaders: ObjectHeaders{
+			ContentType:        aws.StringValue(obj.ContentType),
+			ContentDisposition: aws.StringValue(obj.ContentDisposition),
+			ContentEncoding:    aws.StringValue(obj.ContentEncoding),
+			ContentLanguage:    aws.StringValue(obj.ContentLanguage),
+			CacheControl:       aws.StringValue(obj.CacheControl),
+		},
+		StorageClass: s3StorageClass(obj.StorageClass),
+		Metadata:     s3Metadata(obj.Metadata),
+		Updated:      aws.TimeValue(obj.LastModified),
+	}
+	info.Generation = info.Updated.UnixNano()
+	info.CRC32C, info.HasCRC32C = parseCRC32C(aws.StringValue(obj.ChecksumCRC32C))
+	// Unversioned buckets keep one generation, the one last written
+	if generation != 0 && info.Generation != generation {
+		obj.Body.Close()
+		return nil, nil, fmt.Errorf("%w: %s was modified at %s", errGenerationMismatch, key, info.Updated)
+	}
+	return obj.Body, info, nil
+}
+
+// Put streams an object through the multipart uploader, sized for the
+// object by PartSizing. The SDK sends a Content-MD5 with every part; on
+// top of that a single PUT carries the source CRC32C and has its ETag
+// checked against the streamed MD5.
+func (s *s3Store) Put(ctx context.Context, key string, r io.Reader, opts PutOptions) (*PutResult, error) {
+	size := int64(-1)
+	if opts.Source != nil {
+		size = opts.Source.Size
+	}
+	plan, err := s.sizing.Plan(size)
+	if err != nil {
+		return nil, err
+	}
+
+	body := newChecksumReader(r)
+	input := &s3manager.UploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   body,
+	}
+	setString(&input.ContentType, opts.Headers.ContentType)
+	setString(&input.ContentDisposition, opts.Headers.ContentDisposition)
+	setString(&input.ContentEncoding, opts.Headers.ContentEncoding)
+	setString(&input.ContentLanguage, opts.Headers.ContentLanguage)
+	setString(&input.CacheControl, opts.Headers.CacheControl)
+	if len(opts.Metadata) > 0 {
+		input.Metadata = aws.StringMap(opts.Metadata)
+	}
+	if o := opts.Destination; o != nil {
+		setString(&input.StorageClass, o.StorageClass)
+		setString(&input.ServerSideEncryption, o.SSE)
+		setString(&input.SSEKMSKeyId, o.KMSKeyID)
+		setString(&input.ACL, o.ACL)
+		setString(&input.Tagging, o.Tagging())
+		if o.ObjectLockMode != "" {
+			until, err := o.RetainUntil(time.Now())
+			if err != nil {
+				return nil, fmt.Errorf("object lock retention: %w", err)
+			}
+			input.ObjectLockMode = aws.String(o.ObjectLockMode)
+			input.ObjectLockRetainUntilDate = aws.Time(until)
+		}
+	}
+	// A single PUT lets S3 check the whole body against the CRC32C
+	// recorded by the source
+	if src := opts.Source; src != nil && src.HasCRC32C && !plan.Multipart {
+		input.ChecksumCRC32C = aws.String(crc32cBase64(src.CRC32C))
+	}
+
+	_, err = s.uploader.UploadWithContext(ctx, input, func(u *s3manager.Uploader) {
+		u.PartSize = plan.PartSize
+		// Parts sent at once follow the concurrency controller
+		u.Concurrency = concurrency.Parts()
+	})
+	if err != nil {
+		s.abortUpload(ctx, key, err)
+		return nil, err
+	}
+
+	// The ETag is not compared: it is not an MD5 under bucket default
+	// SSE-KMS, SSE-C or on many S3 compatible stores
+	return &PutResult{Digest: body.Digest(), Upload: plan}, nil
+}
+
+// abortUpload makes sure a failed multipart upload does not leave its
+// parts behind. The uploader aborts with the request context, which is
+// already cancelled when the run is being shut down.
+func (s *s3Store) abortUpload(ctx context.Context, key string, err error) {
+	var multi s3manager.MultiUploadFailure
+	if !errors.As(err, &multi) || multi.UploadID() == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancel()
+	// NoSuchUpload means the uploader got there first
+	s.client.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(multi.UploadID()),
+	})
+}
+
+// Delete checks the generation with a HEAD first. S3 has no conditional
+// delete, so a write landing between the two is not caught.
+func (s *s3Store) Delete(ctx context.Context, key string, generation int64) error {
+	if generation != 0 {
+		info, err := s.Stat(ctx, key)
+		if err != nil {
+			return err
+		}
+		if info.Generation != generation {
+			return fmt.Errorf("%w: %s was modified at %s", errGenerationMismatch, key, info.Updated)
+		}
+	}
+	_, err := s.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	return s3Error(err)
+}
+
+func (s *s3Store) Close() error {
+	return nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// What sync mode compares between a source object and its copy
+const (
+	compareSize     = "size"
+	compareChecksum = "checksum"
+	compareMtime    = "mtime"
+)
+
+// syncDiff says how the copy dst differs from src, "" when it is up to
+// date. Checksums are compared where both sides have the same kind; when
+// neither kind is on both sides, the source generation recorded in the
+// copy's provenance metadata stands in.
+func (c *Config) syncDiff(src, dst *ObjectInfo) string {
+	for _, compare := range c.SyncCompare {
+		switch compare {
+		case compareSize:
+			if dst.Size != src.Size {
+				return fmt.Sprintf("destination has %d bytes, source has %d", dst.Size, src.Size)
+			}
+		case compareChecksum:
+			switch {
+			case dst.HasCRC32C && src.HasCRC32C:
+				if dst.CRC32C != src.CRC32C {
+					return fmt.Sprintf("destination crc32c %08x, source has %08x", dst.CRC32C, src.CRC32C)
+				}
+			case len(dst.MD5) > 0 && len(src.MD5) > 0:
+				if !bytes.Equal(dst.MD5, src.MD5) {
+					return fmt.Sprintf("destination md5 %x, source has %x", dst.MD5, src.MD5)
+				}
+			default:
+				copied, ok := lookupFold(dst.Metadata, metaSourceGeneration)
+				if ok && src.Generation != 0 && copied != strconv.FormatInt(src.Generation, 10) {
+					return fmt.Sprintf("destination is a copy of generation %s, source is at %d", copied, src.Generation)
+				}
+			}
+		case compareMtime:
+			if src.Updated.After(dst.Updated) {
+				return fmt.Sprintf("source updated %s, after the copy from %s",
+					src.Updated.UTC().Format(time.RFC3339), dst.Updated.UTC().Format(time.RFC3339))
+			}
+		}
+	}
+	return ""
+}
+
+// needsCopy decides whether source has to be copied to destination.
+// Without sync mode any existing destination object counts as done. In
+// sync mode it has to match the source, and reason says how it differs.
+func needsCopy(ctx context.Context, config *Config, src, dst ObjectStore, source, destination string) (bool, string) {
+	dstInfo, err := dst.Stat(ctx, destination)
+	if err != nil {
+		return true, ""
+	}
+	if !config.Sync {
+		return false, ""
+	}
+	srcInfo, err := src.Stat(ctx, source)
+	if err != nil {
+		// The copy reports the error when it opens the source
+		return true, ""
+	}
+	if diff := config.syncDiff(srcInfo, dstInfo); diff != "" {
+		return true, diff
+	}
+	return false, ""
+}
+
+// writeScope is the prefix every destination key of the config starts
+// with, cut back to a whole "/" segment. It is "" with no key rules, where
+// keys keep their names. ok is false when the rules do not pin one down:
+// the last rule does not apply to every key, so some keep their names, or
+// the rules add no common prefix.
+func (c *Config) writeScope() (scope string, ok bool) {
+	if len(c.KeyRules) == 0 {
+		return "", true
+	}
+	if last := c.KeyRules[len(c.KeyRules)-1]; last.Match != "" || last.StripPrefix != "" {
+		return "", false
+	}
+	scope = c.KeyRules[0].AddPrefix
+	for _, rule := range c.KeyRules[1:] {
+		for !strings.HasPrefix(rule.AddPrefix, scope) {
+			scope = scope[:len(scope)-1]
+		}
+	}
+	scope = scope[:strings.LastIndex(scope, "/")+1]
+	return scope, scope != ""
+}
+
+// syncDeleteScope is the destination prefix sync_delete looks for extras
+// under, so it never touches objects another job or tool keeps in the same
+// bucket: sync_delete_prefix when set, which has to lie within what the
+// key rules write, otherwise the prefix the key rules add. Without key
+// rules the whole destination mirrors the source.
+func (c *Config) syncDeleteScope() (string, error) {
+	written, ok := c.writeScope()
+	if c.SyncDeletePrefix != "" {
+		if ok && !strings.HasPrefix(c.SyncDeletePrefix, written) {
+			return "", fmt.Errorf("sync_delete_prefix %q is outside %q, where the key rules write", c.SyncDeletePrefix, written)
+		}
+		return c.SyncDeletePrefix, nil
+	}
+	if !ok {
+		return "", fmt.Errorf("sync_delete cannot tell from the key rules which part of the destination they write, set sync_delete_prefix")
+	}
+	return written, nil
+}
+
+// findExtras lists the destination under scope for objects no source
+// object maps to. keep holds the destination keys of everything listed at
+// the source.
+func findExtras(ctx context.Context, dst ObjectStore, scope string, keep map[string]bool) ([]ManifestEntry, error) {
+	var extras []ManifestEntry
+	err := dst.List(ctx, scope, func(info *ObjectInfo) error {
+		if shutdown.Requested() {
+			return errInterrupted
+		}
+		if !keep[info.Key] {
+			extras = append(extras, ManifestEntry{
+				Destination: info.Key,
+				Size:        info.Size,
+				Decision:    DecisionDeleteExtra,
+				Reason:      "not at the source",
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing %s: %w", dst.URI(), err)
+	}
+	return extras, nil
+}
+
+// deleteExtras removes destination objects that are gone from the source,
+// as many as budget allows, and logs each to the deletion manifest. It
+// returns how many were deleted and how many could not be.
+func deleteExtras(
+	ctx context.Context,
+	config *Config,
+	dst ObjectStore,
+	extras []ManifestEntry,
+	budget *deletionBudget,
+	logger *TimestampLogger,
+) (int, int) {
+	if len(extras) == 0 {
+		return 0, 0
+	}
+	logger.Log("")
+	logger.Log("=== Deleting Destination Objects Missing From Source ===")
+	if n := budget.Take(len(extras)); n < len(extras) {
+		logger.Warn("⚠ Deletion cap reached, the rest wait for the next run", "deleting", n, "due", len(extras), "max_deletes", config.MaxDeletes)
+		extras = extras[:n]
+	}
+	logger.Log("Deletion manifest: %s", config.DeletionManifest)
+
+	deletions, err := openDeletionLog(config.DeletionManifest)
+	if err != nil {
+		logger.Error("✗ Not deleting anything", "error", err)
+		return 0, len(extras)
+	}
+	defer deletions.Close()
+
+	deleted, failed := 0, 0
+	for _, extra := range extras {
+		if shutdown.Requested() {
+			break
+		}
+		if err := dst.Delete(ctx, extra.Destination, 0); err != nil {
+			logger.Error("✗ Could not delete destination object", "destination", extra.Destination, "error", err)
+			failed++
+			continue
+		}
+		logger.Detail("✓ Deleted destination object", "destination", extra.Destination, "size", extra.Size, "reason", extra.Reason)
+		deleted++
+		if err := deletions.Write(DeletionRecord{
+			DestinationStore: dst.URI(),
+			Destination:      extra.Destination,
+			Size:             extra.Size,
+			Reason:           extra.Reason,
+			Deleted:          time.Now().UTC(),
+		}); err != nil {
+			logger.Warn("⚠ Deletion manifest write failed", "destination", extra.Destination, "error", err)
+		}
+	}
+	return deleted, failed
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSyncDiff(t *testing.T) {
+	updated := time.Date(2025, 9, 10, 12, 0, 0, 0, time.UTC)
+	src := &ObjectInfo{Size: 10, CRC32C: 1, HasCRC32C: true, MD5: []byte{1}, Generation: 42, Updated: updated}
+	tests := []struct {
+		name    string
+		compare []string
+		dst     ObjectInfo
+		differs bool
+	}{
+		{"same", []string{compareSize, compareChecksum}, ObjectInfo{Size: 10, CRC32C: 1, HasCRC32C: true}, false},
+		{"truncated", []string{compareSize}, ObjectInfo{Size: 4}, true},
+		{"crc32c", []string{compareChecksum}, ObjectInfo{Size: 10, CRC32C: 2, HasCRC32C: true}, true},
+		{"md5", []string{compareChecksum}, ObjectInfo{Size: 10, MD5: []byte{2}}, true},
+		{"older generation", []string{compareChecksum}, ObjectInfo{Size: 10, Metadata: map[string]string{metaSourceGeneration: "41"}}, true},
+		{"same generation", []string{compareChecksum}, ObjectInfo{Size: 10, Metadata: map[string]string{metaSourceGeneration: "42"}}, false},
+		{"nothing to compare", []string{compareChecksum}, ObjectInfo{Size: 10}, false},
+		{"source updated since", []string{compareMtime}, ObjectInfo{Updated: updated.Add(-time.Hour)}, true},
+		{"copy is newer", []string{compareMtime}, ObjectInfo{Updated: updated.Add(time.Hour)}, false},
+		{"size not compared", []string{compareMtime}, ObjectInfo{Size: 4, Updated: updated}, false},
+	}
+	for _, tt := range tests {
+		config := &Config{SyncCompare: tt.compare}
+		if diff := config.syncDiff(src, &tt.dst); (diff != "") != tt.differs {
+			t.Errorf("%s: syncDiff = %q, want differs %v", tt.name, diff, tt.differs)
+		}
+	}
+}
+
+func TestSyncDeleteScope(t *testing.T) {
+	tests := []struct {
+		name   string
+		rules  []KeyRule
+		prefix string
+		want   string
+		err    bool
+	}{
+		{"no rules", nil, "", "", false},
+		{"add prefix", []KeyRule{{AddPrefix: "recordings/"}}, "", "recordings/", false},
+		{"partial segment", []KeyRule{{AddPrefix: "recordings/cam-"}}, "", "recordings/", false},
+		{"common prefix", []KeyRule{{StripPrefix: "a/", AddPrefix: "rec/a/"}, {AddPrefix: "rec/b/"}}, "", "rec/", false},
+		{"no common prefix", []KeyRule{{StripPrefix: "a/", AddPrefix: "a/"}, {AddPrefix: "b/"}}, "", "", true},
+		{"some keys keep their names", []KeyRule{{StripPrefix: "a/", AddPrefix: "rec/"}}, "", "", true},
+		{"prefix where rules are unclear", []KeyRule{{Match: `\.mp4$`, AddPrefix: "rec/"}}, "rec/", "rec/", false},
+		{"prefix within scope", []KeyRule{{AddPrefix: "recordings/"}}, "recordings/port1/", "recordings/port1/", false},
+		{"prefix outside scope", []KeyRule{{AddPrefix: "recordings/"}}, "other_team/", "", true},
+	}
+	for _, tt := range tests {
+		config := &Config{KeyRules: tt.rules, SyncDeletePrefix: tt.prefix}
+		got, err := config.syncDeleteScope()
+		switch {
+		case tt.err && err == nil:
+			t.Errorf("%s: scope %q, want an error", tt.name, got)
+		case !tt.err && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case got != tt.want:
+			t.Errorf("%s: scope %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestSyncRun mirrors a source into recordings/ of a destination that
+// other teams share
+func TestSyncRun(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	put := func(dir, key, content string) {
+		t.Helper()
+		p := filepath.Join(root, dir, filepath.FromSlash(key))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(key string) string {
+		data, err := os.ReadFile(filepath.Join(root, "dst", filepath.FromSlash(key)))
+		if err != nil {
+			return "<missing>"
+		}
+		return string(data)
+	}
+
+	put("src", "port1/2025-09-10/a.mp4", "re-recorded")
+	put("src", "port1/2025-09-10/b.mp4", "unchanged")
+	put("src", "port1/2025-09-10/c.mp4", "new")
+	put("src", "port1/2025-09-10/d.mp4", "moved away")
+	put("dst", "recordings/port1/2025-09-10/a.mp4", "first take")
+	put("dst", "recordings/port1/2025-09-10/b.mp4", "unchanged")
+	put("dst", "recordings/port1/2025-09-01/gone1.mp4", "deleted at the source")
+	put("dst", "recordings/port2/2025-09-01/gone2.mp4", "deleted at the source")
+	put("dst", "other_team/important.mp4", "not ours")
+
+	config, err := LoadConfig("", map[string]string{
+		"source":            filepath.Join(root, "src"),
+		"destination":       filepath.Join(root, "dst"),
+		"log_file":          filepath.Join(root, "migrate.log"),
+		"cutoff_date":       "2025-09-01",
+		"sync":              "true",
+		"sync_delete":       "true",
+		"key_rules":         `[{"add_prefix": "recordings/"}]`,
+		"move":              "true",
+		"move_delay":        "0s",
+		"max_deletes":       "3",
+		"deletion_manifest": filepath.Join(root, "deletions.jsonl"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger, err := NewTimestampLogger(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+	src, dst, err := openStores(ctx, config, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal, err := OpenJournal(config.JournalFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	budget := newDeletionBudget(config)
+	err = runMigration(ctx, config, src, dst, journal, logger, nil, budget, nil)
+	if err := moveAfter(ctx, config, src, dst, journal, budget, logger, err); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range map[string]string{
+		"recordings/port1/2025-09-10/a.mp4": "re-recorded",
+		"recordings/port1/2025-09-10/b.mp4": "unchanged",
+		"recordings/port1/2025-09-10/c.mp4": "new",
+		"recordings/port1/2025-09-10/d.mp4": "moved away",
+		"other_team/important.mp4":          "not ours",
+	} {
+		if got := read(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+	if read("recordings/port1/2025-09-01/gone1.mp4") != "<missing>" || read("recordings/port2/2025-09-01/gone2.mp4") != "<missing>" {
+		t.Error("objects missing from the source were not deleted")
+	}
+
+	// The two sync deletions left one of max_deletes for move mode
+	moved := 0
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if _, err := os.Stat(filepath.Join(root, "src", "port1", "2025-09-10", key+".mp4")); os.IsNotExist(err) {
+			moved++
+		}
+	}
+	if moved != 1 {
+		t.Errorf("move deleted %d sources, want 1 left in the budget", moved)
+	}
+}
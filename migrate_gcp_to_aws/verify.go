@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// errSourceMissing marks entries whose source object can no longer be read
+var errSourceMissing = errors.New("source unavailable")
+
+// verifyStats counts the outcome of a verify run
+type verifyStats struct {
+	ok            atomic.Int64
+	missing       atomic.Int64
+	mismatched    atomic.Int64
+	sourceMissing atomic.Int64
+	errors        atomic.Int64
+}
+
+// verifyObject compares one destination object with its source. The quick
+// check compares sizes and, where both sides have them, the CRC32C and
+// MD5. Multipart S3 copies have neither, so for them it checks the size
+// only. Deep mode reads the destination object back and recomputes both
+// digests.
+func verifyObject(ctx context.Context, entry ManifestEntry, src, dst ObjectStore, deep bool) error {
+	var srcInfo *ObjectInfo
+	if entry.Generation != 0 {
+		// Stat has no generation, so pin it through Open without reading
+		reader, info, err := src.Open(ctx, entry.Source, entry.Generation)
+		if err != nil {
+			return fmt.Errorf("%w: reading source: %v", errSourceMissing, err)
+		}
+		reader.Close()
+		srcInfo = info
+	} else {
+		info, err := src.Stat(ctx, entry.Source)
+		if err != nil {
+			return fmt.Errorf("%w: reading source: %v", errSourceMissing, err)
+		}
+		srcInfo = info
+	}
+
+	dstInfo, err := dst.Stat(ctx, entry.Destination)
+	if err != nil {
+		return err
+	}
+	if dstInfo.Size != srcInfo.Size {
+		return fmt.Errorf("%w: destination has %d bytes, source has %d", errChecksumMismatch, dstInfo.Size, srcInfo.Size)
+	}
+	if dstInfo.HasCRC32C && srcInfo.HasCRC32C && dstInfo.CRC32C != srcInfo.CRC32C {
+		return fmt.Errorf("%w: destination crc32c %08x, source has %08x", errChecksumMismatch, dstInfo.CRC32C, srcInfo.CRC32C)
+	}
+	if len(dstInfo.MD5) > 0 && len(srcInfo.MD5) > 0 && !bytes.Equal(dstInfo.MD5, srcInfo.MD5) {
+		return fmt.Errorf("%w: destination md5 %x, source has %x", errChecksumMismatch, dstInfo.MD5, srcInfo.MD5)
+	}
+	if !deep {
+		return nil
+	}
+
+	reader, _, err := dst.Open(ctx, entry.Destination, 0)
+	if err != nil {
+		return fmt.Errorf("reading destination: %w", err)
+	}
+	defer reader.Close()
+
+	body := newChecksumReader(reader)
+	if _, err := io.Copy(io.Discard, body); err != nil {
+		return fmt.Errorf("reading destination: %w", err)
+	}
+	return verifyChecksums(srcInfo, body.Digest())
+}
+
+// runVerify re-checks already migrated objects against their source
+func runVerify(
+	ctx context.Context,
+	config *Config,
+	src ObjectStore,
+	dst ObjectStore,
+	logger *TimestampLogger,
+	entries []ManifestEntry,
+	deep bool,
+) error {
+	mode := "size and stored checksum"
+	if deep {
+		mode = "full download and checksum"
+	}
+	logger.Log("Verifying %d objects in %s against %s (%s)...", len(entries), dst.URI(), src.URI(), mode)
+	logger.Log("")
+
+	work := make(chan ManifestEntry, config.MaxWorkers*2)
+	stats := &verifyStats{}
+
+	var wg sync.WaitGroup
+	for i := 0; i < config.MaxWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for entry := range work {
+				err := verifyObject(ctx, entry, src, dst, deep)
+				switch {
+				case err == nil:
+					logger.Detail("✓ Verified", "key", entry.Source, "destination", entry.Destination, "size", entry.Size)
+					stats.ok.Add(1)
+				case errors.Is(err, errSourceMissing):
+					logger.Warn("⚠ Source unavailable", "key", entry.Source, "error", err)
+					stats.sourceMissing.Add(1)
+				case errors.Is(err, errObjectNotFound):
+					logger.Error("✗ Missing from destination", "key", entry.Source, "destination", entry.Destination)
+					stats.missing.Add(1)
+				case errors.Is(err, errChecksumMismatch):
+					logger.Error("✗ Mismatched", "key", entry.Source, "destination", entry.Destination, "error", err)
+					stats.mismatched.Add(1)
+				default:
+					logger.Error("✗ Verification failed", "key", entry.Source, "destination", entry.Destination, "error", err)
+					stats.errors.Add(1)
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, entry := range entries {
+		select {
+		case work <- entry:
+		case <-shutdown.Stopping():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	logger.Log("")
+	logger.Log("========================================")
+	logger.Log("          VERIFICATION COMPLETE         ")
+	logger.Log("========================================")
+	logger.Log("")
+	logger.Log("  ✓ Verified: %d", stats.ok.Load())
+	logger.Log("  ✗ Missing from destination: %d", stats.missing.Load())
+	logger.Log("  ✗ Mismatched: %d", stats.mismatched.Load())
+	logger.Log("  ⚠ Source no longer exists: %d", stats.sourceMissing.Load())
+	logger.Log("  ✗ Errors: %d", stats.errors.Load())
+	logger.Log("")
+	logger.Log("========================================")
+
+	if shutdown.Requested() {
+		return errInterrupted
+	}
+	if bad := stats.missing.Load() + stats.mismatched.Load() + stats.errors.Load(); bad > 0 {
+		return fmt.Errorf("%d objects failed verification", bad)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lastRunExpr selects everything dated since the last successful run
+const lastRunExpr = "last-run"
+
+var relativeDate = regexp.MustCompile(`^(?:last\s+)?(\d+)\s*([dw])(?:\s+ago)?$`)
+
+// parseDateExpr turns a date setting into the start of a day in loc.
+// Accepted forms are YYYY-MM-DD, "today", "yesterday", relative days or
+// weeks ("last 30d", "2w ago") and "last-run" (also "since last
+// successful run"), which needs the time of the last successful run.
+func parseDateExpr(value string, now time.Time, loc *time.Location, lastRun func() (time.Time, error)) (time.Time, error) {
+	expr := strings.ToLower(strings.TrimSpace(value))
+	today := startOfDay(now, loc)
+
+	switch expr {
+	case "today":
+		return today, nil
+	case "yesterday":
+		return today.AddDate(0, 0, -1), nil
+	case lastRunExpr, "since last run", "since last successful run":
+		t, err := lastRun()
+		if err != nil {
+			return time.Time{}, err
+		}
+		return startOfDay(t, loc), nil
+	}
+
+	if m := relativeDate.FindStringSubmatch(expr); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		if m[2] == "w" {
+			n *= 7
+		}
+		return today.AddDate(0, 0, -n), nil
+	}
+
+	date, err := time.ParseInLocation("2006-01-02", expr, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not a date, relative date or %s", value, lastRunExpr)
+	}
+	return date, nil
+}
+
+func startOfDay(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
+
+// InWindow reports whether a date falls inside the configured window. The
+// end date is inclusive, so it covers the whole of its day.
+func (c *Config) InWindow(date time.Time) bool {
+	if date.Before(c.CutoffDate) {
+		return false
+	}
+	return c.EndDate.IsZero() || date.Before(c.EndDate.AddDate(0, 0, 1))
+}
+
+// DateWindow describes the window for logs, e.g. "2025-09-07 to 2025-09-14 (UTC)"
+func (c *Config) DateWindow() string {
+	start := c.CutoffDate.Format("2006-01-02")
+	if c.EndDate.IsZero() {
+		return fmt.Sprintf("%s onwards (%s)", start, c.Location)
+	}
+	return fmt.Sprintf("%s to %s (%s)", start, c.EndDate.Format("2006-01-02"), c.Location)
+}
+
+// lastRun is what is remembered of the last successful run
+type lastRun struct {
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+}
+
+// lastRunPath keeps the record of the last successful run next to the journal
+func (c *Config) lastRunPath() string {
+	return filepath.Join(filepath.Dir(c.JournalFile), "migrate_last_run.json")
+}
+
+// LastRun returns when the last successful run started
+func (c *Config) LastRun() (time.Time, error) {
+	data, err := os.ReadFile(c.lastRunPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return time.Time{}, fmt.Errorf("no successful run recorded in %s yet", c.lastRunPath())
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read last run: %w", err)
+	}
+	var run lastRun
+	if err := json.Unmarshal(data, &run); err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse %s: %w", c.lastRunPath(), err)
+	}
+	return run.Started, nil
+}
+
+// RecordRun remembers a successful run for "last-run" windows
+func (c *Config) RecordRun(started time.Time) error {
+	data, err := json.Marshal(lastRun{Started: started, Finished: time.Now()})
+	if err != nil {
+		return err
+	}
+	tmp := c.lastRunPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to record run: %w", err)
+	}
+	if err := os.Rename(tmp, c.lastRunPath()); err != nil {
+		return fmt.Errorf("failed to record run: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseDateExpr(t *testing.T) {
+	now := time.Date(2025, 9, 15, 13, 30, 0, 0, time.UTC)
+	lastRun := func() (time.Time, error) {
+		return time.Date(2025, 9, 12, 22, 15, 0, 0, time.UTC), nil
+	}
+	eastern := time.FixedZone("UTC-5", -5*3600)
+	tokyo := time.FixedZone("UTC+9", 9*3600)
+
+	tests := []struct {
+		value string
+		loc   *time.Location
+		want  time.Time
+	}{
+		{"2025-09-07", time.UTC, time.Date(2025, 9, 7, 0, 0, 0, 0, time.UTC)},
+		{" 2025-09-07 ", eastern, time.Date(2025, 9, 7, 0, 0, 0, 0, eastern)},
+		{"today", time.UTC, time.Date(2025, 9, 15, 0, 0, 0, 0, time.UTC)},
+		{"Today", tokyo, time.Date(2025, 9, 15, 0, 0, 0, 0, tokyo)},
+		{"yesterday", time.UTC, time.Date(2025, 9, 14, 0, 0, 0, 0, time.UTC)},
+		{"last 30d", time.UTC, time.Date(2025, 8, 16, 0, 0, 0, 0, time.UTC)},
+		{"3d", time.UTC, time.Date(2025, 9, 12, 0, 0, 0, 0, time.UTC)},
+		{"2w ago", time.UTC, time.Date(2025, 9, 1, 0, 0, 0, 0, time.UTC)},
+		{"last-run", time.UTC, time.Date(2025, 9, 12, 0, 0, 0, 0, time.UTC)},
+		// The last run was already the next day in Tokyo
+		{"since last successful run", tokyo, time.Date(2025, 9, 13, 0, 0, 0, 0, tokyo)},
+	}
+	for _, tt := range tests {
+		got, err := parseDateExpr(tt.value, now, tt.loc, lastRun)
+		if err != nil {
+			t.Errorf("parseDateExpr(%q): %v", tt.value, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseDateExpr(%q, %s) = %s, want %s", tt.value, tt.loc, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"soon", "2025-13-01", "last 3m", "09/07/2025"} {
+		if _, err := parseDateExpr(bad, now, time.UTC, lastRun); err == nil {
+			t.Errorf("parseDateExpr(%q) was accepted", bad)
+		}
+	}
+
+	errNoRun := errors.New("no successful run recorded")
+	_, err := parseDateExpr("last-run", now, time.UTC, func() (time.Time, error) { return time.Time{}, errNoRun })
+	if !errors.Is(err, errNoRun) {
+		t.Errorf("last-run without a recorded run: got %v, want %v", err, errNoRun)
+	}
+}
+
+func TestDateWindow(t *testing.T) {
+	dir := t.TempDir()
+	config, err := LoadConfig("", map[string]string{
+		"log_file":    filepath.Join(dir, "migrate.log"),
+		"cutoff_date": "2025-09-07",
+		"end_date":    "2025-09-13",
+		"timezone":    "America/New_York",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := config.DateWindow(), "2025-09-07 to 2025-09-13 (America/New_York)"; got != want {
+		t.Errorf("DateWindow() = %q, want %q", got, want)
+	}
+
+	// Folder dates are days in the configured timezone, the end day
+	// included
+	tests := []struct {
+		date string
+		in   bool
+	}{
+		{"2025-09-06", false},
+		{"2025-09-07", true},
+		{"2025-09-13", true},
+		{"2025-09-14", false},
+	}
+	for _, tt := range tests {
+		date, err := time.ParseInLocation("2006-01-02", tt.date, config.Location)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := config.InWindow(date); got != tt.in {
+			t.Errorf("InWindow(%s) = %v, want %v", tt.date, got, tt.in)
+		}
+	}
+
+	if _, err := LoadConfig("", map[string]string{"cutoff_date": "2025-09-07", "end_date": "2025-09-01"}); err == nil {
+		t.Error("end_date before cutoff_date was accepted")
+	}
+	if _, err := LoadConfig("", map[string]string{"timezone": "Mars/Olympus_Mons"}); err == nil {
+		t.Error("unknown timezone was accepted")
+	}
+}
+
+func TestSinceLastRun(t *testing.T) {
+	overrides := map[string]string{
+		"log_file":    filepath.Join(t.TempDir(), "migrate.log"),
+		"cutoff_date": "since last successful run",
+	}
+	if _, err := LoadConfig("", overrides); err == nil {
+		t.Fatal("last-run window without a recorded run was accepted")
+	}
+
+	config, err := LoadConfig("", map[string]string{"log_file": overrides["log_file"]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := time.Date(2025, 9, 12, 22, 15, 0, 0, time.UTC)
+	if err := config.RecordRun(started); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err = LoadConfig("", overrides)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2025, 9, 12, 0, 0, 0, 0, time.UTC); !config.CutoffDate.Equal(want) {
+		t.Errorf("cutoff %s, want %s", config.CutoffDate, want)
+	}
+}